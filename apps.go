@@ -5,10 +5,24 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"math"
+	"strings"
 
 	"github.com/elgs/gosqljson"
+	"github.com/elgs/gostrgen"
 )
 
+// newAppDbName returns a database name for a new app, the first letters of the
+// app name followed by random ones.
+func newAppDbName(name string) (string, error) {
+	namePrefix := name[:int(math.Min(float64(len(name)), 8))]
+	dbName, err := gostrgen.RandGen(16-len(namePrefix), gostrgen.LowerDigit, "", "")
+	if err != nil {
+		return "", err
+	}
+	return namePrefix + dbName, nil
+}
+
 func (this *App) OnAppCreateOrUpdate() error {
 	var dn *DataNode = nil
 	for iDn, vDn := range masterData.DataNodes {
@@ -43,6 +57,21 @@ func (this *App) OnAppCreateOrUpdate() error {
 	return nil
 }
 
+// createAppDatabases creates the databases of the apps of target that are new
+// or moved to another data node, before target replaces this master data.
+func (this *MasterData) createAppDatabases(target *MasterData) error {
+	for _, app := range target.Apps {
+		vApp := this.findApp(app.Id)
+		if vApp == nil || vApp.DataNodeId != app.DataNodeId {
+			err := app.OnAppCreateOrUpdate()
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
 func (this *App) OnAppRemove() error {
 	var dn *DataNode = nil
 	for iDn, vDn := range masterData.DataNodes {
@@ -77,3 +106,34 @@ func (this *App) OnAppRemove() error {
 	}
 	return nil
 }
+
+// CheckRemove checks that the database of the app can be dropped, before
+// OnAppRemove drops it.
+func (this *App) CheckRemove() error {
+	var dn *DataNode = nil
+	for iDn, vDn := range masterData.DataNodes {
+		if this.DataNodeId == vDn.Id {
+			dn = masterData.DataNodes[iDn]
+			break
+		}
+	}
+
+	if dn == nil {
+		return errors.New("Data node not found: " + this.DataNodeId)
+	}
+	if strings.TrimSpace(this.DbName) == "" {
+		return errors.New("App has no database: " + this.Name)
+	}
+
+	ds := fmt.Sprintf("%v:%v@tcp(%v:%v)/", dn.Username, dn.Password, dn.Host, dn.Port)
+	appDb, err := sql.Open("mysql", ds)
+	if err != nil {
+		return err
+	}
+	defer appDb.Close()
+	err = appDb.Ping()
+	if err != nil {
+		return errors.New("Cannot remove app " + this.Name + ", data node not reachable: " + err.Error())
+	}
+	return nil
+}
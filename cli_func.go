@@ -4,66 +4,258 @@ package main
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/urfave/cli"
 )
 
+var cliMutex = &sync.Mutex{}
+
+// cliMeta collects the options shared by cli commands into Command.Meta.
+func cliMeta(c *cli.Context) map[string]interface{} {
+	meta := map[string]interface{}{}
+	if c.IsSet("expect") {
+		meta["expect_version"] = c.Int64("expect")
+	}
+	if c.Bool("cascade") {
+		meta["cascade"] = true
+	}
+	if c.Bool("dry-run") {
+		meta["dry_run"] = true
+	}
+	if c.Bool("prune") {
+		meta["prune"] = true
+	}
+	return meta
+}
+
+// setPatchFields adds the string flags set on the command line to a json
+// merge patch, under the names of the fields they update. A flag set to an
+// empty string, e.g. --note "", clears its field with a null.
+func setPatchFields(c *cli.Context, patch map[string]interface{}, fields map[string]string) {
+	for flag, field := range fields {
+		if c.IsSet(flag) {
+			if value := c.String(flag); value != "" {
+				patch[field] = value
+			} else {
+				patch[field] = nil
+			}
+		}
+	}
+}
+
+// setScriptFields sets the script fields of a query or a job, or of a json
+// merge patch of one, from the command line. Files given with --file and
+// --loopfile are read here and sent as text, paths given with --script and
+// --loopscript are read by the master. The script and the loop script of a
+// job are set independently.
+func setScriptFields(c *cli.Context, target interface{}) error {
+	if c.IsSet("file") && c.IsSet("script") {
+		return errors.New("The script is either uploaded with --file, or read by the master with --script, not both.")
+	}
+	if c.IsSet("loopfile") && c.IsSet("loopscript") {
+		return errors.New("The loop script is either uploaded with --loopfile, or read by the master with --loopscript, not both.")
+	}
+	fields := map[string]interface{}{}
+	if c.IsSet("file") {
+		content, err := ioutil.ReadFile(c.String("file"))
+		if err != nil {
+			return err
+		}
+		fields["ScriptSource"] = ScriptSourceText
+		fields["ScriptPath"] = ""
+		fields["ScriptText"] = string(content)
+	} else if c.IsSet("script") {
+		fields["ScriptSource"] = ScriptSourcePath
+		fields["ScriptPath"] = c.String("script")
+	}
+	if c.IsSet("loopfile") {
+		content, err := ioutil.ReadFile(c.String("loopfile"))
+		if err != nil {
+			return err
+		}
+		fields["LoopScriptSource"] = ScriptSourceText
+		fields["LoopScriptPath"] = ""
+		fields["LoopScriptText"] = string(content)
+	} else if c.IsSet("loopscript") {
+		fields["LoopScriptSource"] = ScriptSourcePath
+		fields["LoopScriptPath"] = c.String("loopscript")
+	}
+	if len(fields) == 0 {
+		return nil
+	}
+	return deepCopy(fields, target)
+}
+
+// entityRef locates the entity a json merge patch applies to.
+type entityRef struct {
+	Id    string
+	AppId string
+}
+
+// changeEntities maps the kinds of entities of cli commands to the entities
+// of master data changes.
+var changeEntities = map[string]string{
+	"DN":    "DataNode",
+	"APP":   "App",
+	"QUERY": "Query",
+	"JOB":   "Job",
+	"TOKEN": "Token",
+	"LI":    "LocalInterceptor",
+	"RI":    "RemoteInterceptor",
+}
+
+// checkExpectedVersion rejects update and remove commands made against a
+// version of the master data older than the last change of their entity.
+// Changes to other entities since do not conflict. It runs after resolveRefs,
+// on the ids of the entities.
+func checkExpectedVersion(cliCommand *Command) error {
+	if !strings.HasSuffix(cliCommand.Type, "_UPDATE") && !strings.HasSuffix(cliCommand.Type, "_REMOVE") {
+		return nil
+	}
+	expected, ok := cliCommand.Meta["expect_version"].(float64)
+	if !ok {
+		return nil
+	}
+	parts := strings.SplitN(strings.TrimPrefix(cliCommand.Type, "CLI_"), "_", 2)
+	entity := changeEntities[parts[0]]
+	ref := &entityRef{}
+	if cliCommand.Type == "CLI_DN_REMOVE" || cliCommand.Type == "CLI_APP_REMOVE" {
+		ref.Id = cliCommand.Data
+	} else {
+		err := json.Unmarshal([]byte(cliCommand.Data), ref)
+		if err != nil {
+			return err
+		}
+	}
+	if entity == "DataNode" || entity == "App" {
+		ref.AppId = ""
+	}
+	changed := changedVersion(entity, ref.AppId, ref.Id)
+	if changed > int64(expected) {
+		return errors.New(fmt.Sprint("Conflict: ", entityNames[parts[0]], " ", ref.Id, " changed at version ", changed,
+			", after the expected version ", int64(expected), ". Review the changes and retry."))
+	}
+	return nil
+}
+
+// readOnlyCliCommand tells whether a cli command leaves the master data, the
+// databases and the jobs as they are, and is served by a fenced master.
+func readOnlyCliCommand(commandType string) bool {
+	return strings.HasSuffix(commandType, "_LIST") || strings.HasSuffix(commandType, "_DESCRIBE") ||
+		strings.HasPrefix(commandType, "CLI_SHOW_") || commandType == "CLI_MASTER_HISTORY"
+}
+
 func processCliCommand(message []byte) (string, error) {
 	cliCommand := &Command{}
 	json.Unmarshal(message, cliCommand)
 	if service.Secret != cliCommand.Secret {
 		return "", errors.New("Failed to validate secret.")
 	}
+	if isFenced() && !readOnlyCliCommand(cliCommand.Type) {
+		return "", errFenced
+	}
+	if cliCommand.Type == "CLI_SLAVE_OP" {
+		// Waits for the slaves, without holding up the other commands.
+		slaveOp := &SlaveOp{}
+		err := json.Unmarshal([]byte(cliCommand.Data), slaveOp)
+		if err != nil {
+			return "", err
+		}
+		return RunSlaveOp(slaveOp.Op, slaveOp.Target)
+	}
+	if cliCommand.Type == "CLI_QUERY_EXEC" {
+		// Runs on the database, without holding up the other commands.
+		queryExec, err := resolveQueryExec(cliCommand)
+		if err != nil {
+			return "", err
+		}
+		return queryExec.Run()
+	}
+	cliMutex.Lock()
+	defer cliMutex.Unlock()
+	err := resolveRefs(cliCommand)
+	if err != nil {
+		return "", err
+	}
+	err = checkExpectedVersion(cliCommand)
+	if err != nil {
+		return "", err
+	}
+	cascade, _ := cliCommand.Meta["cascade"].(bool)
+	version := masterData.Version
+	defer func() {
+		if masterData.Version != version {
+			err := masterHistory.Record(&masterData, cliCommand.Type)
+			if err != nil {
+				log.Println(err)
+			}
+		}
+	}()
 	switch cliCommand.Type {
-	case "CLI_DN_LIST":
-		return masterData.ListDataNodes(cliCommand.Data), nil
+	case "CLI_DN_LIST", "CLI_APP_LIST", "CLI_QUERY_LIST", "CLI_JOB_LIST", "CLI_TOKEN_LIST", "CLI_LI_LIST", "CLI_RI_LIST",
+		"CLI_DN_DESCRIBE", "CLI_APP_DESCRIBE", "CLI_QUERY_DESCRIBE", "CLI_JOB_DESCRIBE", "CLI_TOKEN_DESCRIBE", "CLI_LI_DESCRIBE", "CLI_RI_DESCRIBE":
+		return processListCommand(cliCommand)
 	case "CLI_DN_ADD":
 		dataNode := &DataNode{}
 		err := json.Unmarshal([]byte(cliCommand.Data), dataNode)
 		if err != nil {
 			return "", err
 		}
+		err = dataNode.Validate()
+		if err != nil {
+			return "", err
+		}
 		err = masterData.AddDataNode(dataNode)
 		if err != nil {
 			return "", err
 		}
 	case "CLI_DN_UPDATE":
-		dataNode := &DataNode{}
-		err := json.Unmarshal([]byte(cliCommand.Data), dataNode)
+		ref := &entityRef{}
+		err := json.Unmarshal([]byte(cliCommand.Data), ref)
 		if err != nil {
 			return "", err
 		}
-		err = masterData.UpdateDataNode(dataNode)
+		err = masterData.UpdateDataNode(ref.Id, []byte(cliCommand.Data))
 		if err != nil {
 			return "", err
 		}
 	case "CLI_DN_REMOVE":
-		err := masterData.RemoveDataNode(cliCommand.Data)
+		err := masterData.RemoveDataNode(cliCommand.Data, cascade)
 		if err != nil {
 			return "", err
 		}
-	case "CLI_APP_LIST":
-		return masterData.ListApps(cliCommand.Data), nil
 	case "CLI_APP_ADD":
 		app := &App{}
 		err := json.Unmarshal([]byte(cliCommand.Data), app)
 		if err != nil {
 			return "", err
 		}
+		err = app.Validate()
+		if err != nil {
+			return "", err
+		}
 		err = masterData.AddApp(app)
 		if err != nil {
 			return "", err
 		}
 	case "CLI_APP_UPDATE":
-		app := &App{}
-		err := json.Unmarshal([]byte(cliCommand.Data), app)
+		ref := &entityRef{}
+		err := json.Unmarshal([]byte(cliCommand.Data), ref)
 		if err != nil {
 			return "", err
 		}
-		err = masterData.UpdateApp(app)
+		err = masterData.UpdateApp(ref.Id, []byte(cliCommand.Data))
 		if err != nil {
 			return "", err
 		}
 	case "CLI_APP_REMOVE":
-		err := masterData.RemoveApp(cliCommand.Data)
+		err := masterData.RemoveApp(cliCommand.Data, cascade)
 		if err != nil {
 			return "", err
 		}
@@ -73,17 +265,21 @@ func processCliCommand(message []byte) (string, error) {
 		if err != nil {
 			return "", err
 		}
+		err = query.Validate()
+		if err != nil {
+			return "", err
+		}
 		err = masterData.AddQuery(query)
 		if err != nil {
 			return "", err
 		}
 	case "CLI_QUERY_UPDATE":
-		query := &Query{}
-		err := json.Unmarshal([]byte(cliCommand.Data), query)
+		ref := &entityRef{}
+		err := json.Unmarshal([]byte(cliCommand.Data), ref)
 		if err != nil {
 			return "", err
 		}
-		err = masterData.UpdateQuery(query)
+		err = masterData.UpdateQuery(ref.Id, ref.AppId, []byte(cliCommand.Data))
 		if err != nil {
 			return "", err
 		}
@@ -98,7 +294,7 @@ func processCliCommand(message []byte) (string, error) {
 		if err != nil {
 			return "", err
 		}
-		err = masterData.RemoveQuery(query.Id, query.AppId)
+		err = masterData.RemoveQuery(query.Id, query.AppId, cascade)
 		if err != nil {
 			return "", err
 		}
@@ -108,17 +304,21 @@ func processCliCommand(message []byte) (string, error) {
 		if err != nil {
 			return "", err
 		}
+		err = job.Validate()
+		if err != nil {
+			return "", err
+		}
 		err = masterData.AddJob(job)
 		if err != nil {
 			return "", err
 		}
 	case "CLI_JOB_UPDATE":
-		job := &Job{}
-		err := json.Unmarshal([]byte(cliCommand.Data), job)
+		ref := &entityRef{}
+		err := json.Unmarshal([]byte(cliCommand.Data), ref)
 		if err != nil {
 			return "", err
 		}
-		err = masterData.UpdateJob(job)
+		err = masterData.UpdateJob(ref.Id, ref.AppId, []byte(cliCommand.Data))
 		if err != nil {
 			return "", err
 		}
@@ -168,17 +368,21 @@ func processCliCommand(message []byte) (string, error) {
 		if err != nil {
 			return "", err
 		}
+		err = token.Validate()
+		if err != nil {
+			return "", err
+		}
 		err = masterData.AddToken(token)
 		if err != nil {
 			return "", err
 		}
 	case "CLI_TOKEN_UPDATE":
-		token := &Token{}
-		err := json.Unmarshal([]byte(cliCommand.Data), token)
+		ref := &entityRef{}
+		err := json.Unmarshal([]byte(cliCommand.Data), ref)
 		if err != nil {
 			return "", err
 		}
-		err = masterData.UpdateToken(token)
+		err = masterData.UpdateToken(ref.Id, ref.AppId, []byte(cliCommand.Data))
 		if err != nil {
 			return "", err
 		}
@@ -198,17 +402,21 @@ func processCliCommand(message []byte) (string, error) {
 		if err != nil {
 			return "", err
 		}
+		err = li.Validate()
+		if err != nil {
+			return "", err
+		}
 		err = masterData.AddLI(li)
 		if err != nil {
 			return "", err
 		}
 	case "CLI_LI_UPDATE":
-		li := &LocalInterceptor{}
-		err := json.Unmarshal([]byte(cliCommand.Data), li)
+		ref := &entityRef{}
+		err := json.Unmarshal([]byte(cliCommand.Data), ref)
 		if err != nil {
 			return "", err
 		}
-		err = masterData.UpdateLI(li)
+		err = masterData.UpdateLI(ref.Id, ref.AppId, []byte(cliCommand.Data))
 		if err != nil {
 			return "", err
 		}
@@ -228,17 +436,21 @@ func processCliCommand(message []byte) (string, error) {
 		if err != nil {
 			return "", err
 		}
+		err = ri.Validate()
+		if err != nil {
+			return "", err
+		}
 		err = masterData.AddRI(ri)
 		if err != nil {
 			return "", err
 		}
 	case "CLI_RI_UPDATE":
-		ri := &RemoteInterceptor{}
-		err := json.Unmarshal([]byte(cliCommand.Data), ri)
+		ref := &entityRef{}
+		err := json.Unmarshal([]byte(cliCommand.Data), ref)
 		if err != nil {
 			return "", err
 		}
-		err = masterData.UpdateRI(ri)
+		err = masterData.UpdateRI(ref.Id, ref.AppId, []byte(cliCommand.Data))
 		if err != nil {
 			return "", err
 		}
@@ -259,11 +471,29 @@ func processCliCommand(message []byte) (string, error) {
 		}
 		return string(masterDataBytes), nil
 	case "CLI_SHOW_API_NODES":
-		apiNodesBytes, err := json.Marshal(apiNodes)
+		return ListApiNodes(masterData.Version), nil
+	case "CLI_MASTER_HISTORY":
+		return masterHistory.List(), nil
+	case "CLI_MASTER_ROLLBACK":
+		version, err := strconv.ParseInt(cliCommand.Data, 10, 64)
+		if err != nil {
+			return "", err
+		}
+		err = masterData.Rollback(version)
+		if err != nil {
+			return "", err
+		}
+	case "CLI_MASTER_IMPORT":
+		imported := &MasterData{}
+		err := json.Unmarshal([]byte(cliCommand.Data), imported)
 		if err != nil {
 			return "", err
 		}
-		return string(apiNodesBytes), nil
+		dryRun, _ := cliCommand.Meta["dry_run"].(bool)
+		prune, _ := cliCommand.Meta["prune"].(bool)
+		return masterData.Import(imported, dryRun, prune)
+	case "CLI_SHOW_STATS":
+		return ListStats(cliCommand.Data), nil
 	case "CLI_PROPAGATE":
 		err := masterData.Propagate()
 		if err != nil {
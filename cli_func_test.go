@@ -0,0 +1,104 @@
+// cli_func_test
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/urfave/cli"
+)
+
+func TestSetScriptFields(t *testing.T) {
+	dir := t.TempDir()
+	scriptFile := filepath.Join(dir, "job.sql")
+	loopFile := filepath.Join(dir, "job_loop.sql")
+	err := ioutil.WriteFile(scriptFile, []byte("delete from t where id=$0"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(loopFile, []byte("select id from t"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		args    []string
+		job     Job
+		want    Job
+		wantErr bool
+	}{
+		{"nothing set", nil, Job{ScriptSource: ScriptSourcePath, ScriptPath: "a.sql"}, Job{ScriptSource: ScriptSourcePath, ScriptPath: "a.sql"}, false},
+		{"upload script", []string{"--file", scriptFile}, Job{ScriptSource: ScriptSourcePath, ScriptPath: "a.sql"},
+			Job{ScriptSource: ScriptSourceText, ScriptText: "delete from t where id=$0"}, false},
+		{"script path", []string{"--script", "b.sql"}, Job{ScriptSource: ScriptSourceText, ScriptText: "x"},
+			Job{ScriptSource: ScriptSourcePath, ScriptPath: "b.sql", ScriptText: "x"}, false},
+		{"upload loop script only", []string{"--loopfile", loopFile}, Job{ScriptSource: ScriptSourcePath, ScriptPath: "a.sql"},
+			Job{ScriptSource: ScriptSourcePath, ScriptPath: "a.sql", LoopScriptSource: ScriptSourceText, LoopScriptText: "select id from t"}, false},
+		{"script path and uploaded loop script", []string{"--script", "b.sql", "--loopfile", loopFile}, Job{},
+			Job{ScriptSource: ScriptSourcePath, ScriptPath: "b.sql", LoopScriptSource: ScriptSourceText, LoopScriptText: "select id from t"}, false},
+		{"loop script path", []string{"--loopscript", "c.sql"}, Job{LoopScriptSource: ScriptSourceText, LoopScriptText: "y"},
+			Job{LoopScriptSource: ScriptSourcePath, LoopScriptPath: "c.sql", LoopScriptText: "y"}, false},
+		{"file and script", []string{"--file", scriptFile, "--script", "b.sql"}, Job{}, Job{}, true},
+		{"loopfile and loopscript", []string{"--loopfile", loopFile, "--loopscript", "c.sql"}, Job{}, Job{}, true},
+		{"missing file", []string{"--file", filepath.Join(dir, "missing.sql")}, Job{}, Job{}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			set := flag.NewFlagSet(test.name, flag.ContinueOnError)
+			for _, name := range []string{"file", "script", "loopfile", "loopscript"} {
+				set.String(name, "", "")
+			}
+			err := set.Parse(test.args)
+			if err != nil {
+				t.Fatal(err)
+			}
+			job := test.job
+			err = setScriptFields(cli.NewContext(nil, set, nil), &job)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("err = %v, want error %v", err, test.wantErr)
+			}
+			if !test.wantErr && job != test.want {
+				t.Errorf("job = %+v, want %+v", job, test.want)
+			}
+		})
+	}
+}
+
+func TestCheckExpectedVersion(t *testing.T) {
+	defer resetChangedVersions(0)
+	resetChangedVersions(5)
+	change, err := NewMasterDataChange("Query", "update", &Query{Id: "q1", AppId: "app1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	recordChangedVersions([]*MasterDataChange{change}, 7)
+	command := func(commandType string, data string, expect int64) *Command {
+		return &Command{Type: commandType, Data: data, Meta: map[string]interface{}{"expect_version": float64(expect)}}
+	}
+
+	if err := checkExpectedVersion(command("CLI_QUERY_UPDATE", `{"Id":"q1","AppId":"app1"}`, 6)); err == nil {
+		t.Error("update of a query changed after the expected version accepted")
+	}
+	if err := checkExpectedVersion(command("CLI_QUERY_REMOVE", `{"Id":"q1","AppId":"app1"}`, 7)); err != nil {
+		t.Error(err)
+	}
+	// Changes to other entities do not conflict.
+	if err := checkExpectedVersion(command("CLI_QUERY_UPDATE", `{"Id":"q2","AppId":"app1"}`, 5)); err != nil {
+		t.Error(err)
+	}
+	if err := checkExpectedVersion(command("CLI_JOB_UPDATE", `{"Id":"q1","AppId":"app1"}`, 5)); err != nil {
+		t.Error(err)
+	}
+	if err := checkExpectedVersion(command("CLI_DN_REMOVE", "dn1", 5)); err != nil {
+		t.Error(err)
+	}
+	// Entities not changed since the master started count as changed then.
+	if err := checkExpectedVersion(command("CLI_APP_REMOVE", "app1", 4)); err == nil {
+		t.Error("remove against a version older than the start of the master accepted")
+	}
+	if err := checkExpectedVersion(&Command{Type: "CLI_QUERY_UPDATE", Data: `{"Id":"q1","AppId":"app1"}`}); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,347 @@
+// contexts
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/urfave/cli"
+)
+
+// A cli context names a cluster, with the node the cli talks to and the
+// credentials it needs there. The contexts are kept in ~/.netdata/contexts.
+// The active context, or the one given with --context, fills in the options
+// not given on the command line.
+
+type CliContext struct {
+	Name           string
+	Node           string
+	Secret         string
+	SecretFile     string
+	CaFile         string
+	ClientCertFile string
+	ClientKeyFile  string
+	App            string
+}
+
+type CliContexts struct {
+	Current  string
+	Contexts []*CliContext
+}
+
+func cliContextsFile() string {
+	return filepath.Join(homeDir, ".netdata", "contexts")
+}
+
+func loadCliContexts() (*CliContexts, error) {
+	contexts := &CliContexts{}
+	content, err := ioutil.ReadFile(cliContextsFile())
+	if os.IsNotExist(err) {
+		return contexts, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(content, contexts)
+	if err != nil {
+		return nil, errors.New("Failed to parse " + cliContextsFile() + ": " + err.Error())
+	}
+	return contexts, nil
+}
+
+// save writes the contexts readable by the user only, as they hold secrets.
+func (this *CliContexts) save() error {
+	content, err := json.MarshalIndent(this, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(cliContextsFile()), 0700)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(cliContextsFile(), content, 0600)
+}
+
+func (this *CliContexts) Get(name string) *CliContext {
+	for _, v := range this.Contexts {
+		if v.Name == name {
+			return v
+		}
+	}
+	return nil
+}
+
+var activeContext *CliContext
+
+// loadActiveContext loads the context given with --context, or the current
+// one, if any.
+func loadActiveContext(c *cli.Context) (*CliContext, error) {
+	contexts, err := loadCliContexts()
+	if err != nil {
+		return nil, err
+	}
+	name := contexts.Current
+	if c.GlobalIsSet("context") {
+		name = c.GlobalString("context")
+	}
+	if name == "" {
+		return nil, nil
+	}
+	context := contexts.Get(name)
+	if context == nil {
+		return nil, errors.New("Context not found: " + name)
+	}
+	return context, nil
+}
+
+// LoadContext fills in the secret and the certificates from the active
+// context, unless given on the command line.
+func (this *CliService) LoadContext(c *cli.Context) error {
+	context, err := loadActiveContext(c)
+	if err != nil {
+		return err
+	}
+	activeContext = context
+	if context == nil {
+		return nil
+	}
+	if !c.IsSet("secret") {
+		if context.Secret != "" {
+			this.Secret = context.Secret
+		} else if context.SecretFile != "" {
+			secret, err := ioutil.ReadFile(context.SecretFile)
+			if err != nil {
+				return errors.New("Failed to read secret file: " + err.Error())
+			}
+			this.Secret = strings.TrimSpace(string(secret))
+		}
+	}
+	if !c.IsSet("ca_file") && !c.GlobalIsSet("ca_file") && context.CaFile != "" {
+		this.CaFile = context.CaFile
+	}
+	if !c.IsSet("client_cert_file") && !c.GlobalIsSet("client_cert_file") && context.ClientCertFile != "" {
+		this.ClientCertFile = context.ClientCertFile
+	}
+	if !c.IsSet("client_key_file") && !c.GlobalIsSet("client_key_file") && context.ClientKeyFile != "" {
+		this.ClientKeyFile = context.ClientKeyFile
+	}
+	return nil
+}
+
+// cliNode returns the node given with --node, or the node of the active
+// context, or the default node.
+func cliNode(c *cli.Context) string {
+	if !c.IsSet("node") && activeContext != nil && activeContext.Node != "" {
+		return activeContext.Node
+	}
+	return c.String("node")
+}
+
+// cliApp returns the app given with --app, or the default app of the active
+// context.
+func cliApp(c *cli.Context) string {
+	if !c.IsSet("app") && activeContext != nil {
+		return activeContext.App
+	}
+	return c.String("app")
+}
+
+func (this *CliContext) describe() string {
+	var buffer bytes.Buffer
+	w := tabwriter.NewWriter(&buffer, 0, 4, 2, ' ', 0)
+	secret := ""
+	if this.Secret != "" {
+		secret = "********"
+	} else if this.SecretFile != "" {
+		secret = "file " + this.SecretFile
+	}
+	fmt.Fprintf(w, "Name:\t%v\n", this.Name)
+	fmt.Fprintf(w, "Node:\t%v\n", this.Node)
+	fmt.Fprintf(w, "Secret:\t%v\n", secret)
+	fmt.Fprintf(w, "CaFile:\t%v\n", this.CaFile)
+	fmt.Fprintf(w, "ClientCertFile:\t%v\n", this.ClientCertFile)
+	fmt.Fprintf(w, "ClientKeyFile:\t%v\n", this.ClientKeyFile)
+	fmt.Fprintf(w, "App:\t%v\n", this.App)
+	w.Flush()
+	return strings.TrimSpace(buffer.String())
+}
+
+func contextCommand() cli.Command {
+	return cli.Command{
+		Name:  "context",
+		Usage: "cli contexts, naming the node and credentials of a cluster",
+		Subcommands: []cli.Command{
+			{
+				Name:  "add",
+				Usage: "add or update a context",
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:  "name, n",
+						Usage: "name of the context",
+					},
+					cli.StringFlag{
+						Name:  "node, N",
+						Usage: "node url, format: host:port",
+					},
+					cli.StringFlag{
+						Name:  "secret, z",
+						Usage: "secret password for server client communication.",
+					},
+					cli.StringFlag{
+						Name:  "secret_file",
+						Usage: "file holding the secret password, instead of the secret itself",
+					},
+					cli.StringFlag{
+						Name:  "ca_file",
+						Usage: "cluster CA file path",
+					},
+					cli.StringFlag{
+						Name:  "client_cert_file",
+						Usage: "client cert file path",
+					},
+					cli.StringFlag{
+						Name:  "client_key_file",
+						Usage: "client key file path",
+					},
+					cli.StringFlag{
+						Name:  "app, a",
+						Usage: "default app name or id",
+					},
+					cli.BoolFlag{
+						Name:  "use",
+						Usage: "make it the current context",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					name := strings.TrimSpace(c.String("name"))
+					if name == "" {
+						err := errors.New("Context name is required.")
+						fmt.Println(err)
+						return err
+					}
+					if c.IsSet("secret") && c.IsSet("secret_file") {
+						err := errors.New("Either a secret or a secret file, not both.")
+						fmt.Println(err)
+						return err
+					}
+					contexts, err := loadCliContexts()
+					if err != nil {
+						fmt.Println(err)
+						return err
+					}
+					context := contexts.Get(name)
+					if context == nil {
+						context = &CliContext{Name: name}
+						contexts.Contexts = append(contexts.Contexts, context)
+					}
+					if c.IsSet("node") {
+						context.Node = c.String("node")
+					}
+					if c.IsSet("secret") {
+						context.Secret = c.String("secret")
+						context.SecretFile = ""
+					}
+					if c.IsSet("secret_file") {
+						context.SecretFile = c.String("secret_file")
+						context.Secret = ""
+					}
+					if c.IsSet("ca_file") {
+						context.CaFile = c.String("ca_file")
+					}
+					if c.IsSet("client_cert_file") {
+						context.ClientCertFile = c.String("client_cert_file")
+					}
+					if c.IsSet("client_key_file") {
+						context.ClientKeyFile = c.String("client_key_file")
+					}
+					if c.IsSet("app") {
+						context.App = c.String("app")
+					}
+					if c.Bool("use") || contexts.Current == "" {
+						contexts.Current = name
+					}
+					err = contexts.save()
+					if err != nil {
+						fmt.Println(err)
+						return err
+					}
+					return nil
+				},
+			},
+			{
+				Name:      "use",
+				Usage:     "switch to a context",
+				ArgsUsage: "<name>",
+				Action: func(c *cli.Context) error {
+					name := c.Args().First()
+					contexts, err := loadCliContexts()
+					if err != nil {
+						fmt.Println(err)
+						return err
+					}
+					if contexts.Get(name) == nil {
+						err := errors.New("Context not found: " + name)
+						fmt.Println(err)
+						return err
+					}
+					contexts.Current = name
+					err = contexts.save()
+					if err != nil {
+						fmt.Println(err)
+						return err
+					}
+					fmt.Println("Switched to context", name)
+					return nil
+				},
+			},
+			{
+				Name:  "list",
+				Usage: "list the contexts",
+				Action: func(c *cli.Context) error {
+					contexts, err := loadCliContexts()
+					if err != nil {
+						fmt.Println(err)
+						return err
+					}
+					var buffer bytes.Buffer
+					w := tabwriter.NewWriter(&buffer, 0, 4, 2, ' ', 0)
+					fmt.Fprintln(w, "CURRENT\tNAME\tNODE\tAPP")
+					for _, v := range contexts.Contexts {
+						current := ""
+						if v.Name == contexts.Current {
+							current = "*"
+						}
+						fmt.Fprintf(w, "%v\t%v\t%v\t%v\n", current, v.Name, v.Node, v.App)
+					}
+					w.Flush()
+					fmt.Println(strings.TrimSpace(buffer.String()))
+					return nil
+				},
+			},
+			{
+				Name:  "current",
+				Usage: "show the current context",
+				Action: func(c *cli.Context) error {
+					context, err := loadActiveContext(c)
+					if err != nil {
+						fmt.Println(err)
+						return err
+					}
+					if context == nil {
+						fmt.Println("No current context.")
+						return nil
+					}
+					fmt.Println(context.describe())
+					return nil
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,447 @@
+// export
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/satori/go.uuid"
+)
+
+// The export directory holds one <name>.datanode.json file per data node and
+// one <name>.app.json file per app. The scripts of an app are written the way
+// Query.Reload and Job.Reload look for them under .netdata: <app>/<name>.sql
+// for queries and jobs, and <app>/<name>_loop.sql for the loop scripts of
+// jobs. Scripts read from the export are imported as text. The passwords of
+// the data nodes are left out, an imported data node without password keeps
+// the password it has on the master. Ids left out of files written by hand
+// are generated on import.
+
+func exportFileName(name string) (string, error) {
+	if strings.TrimSpace(name) == "" || strings.ContainsAny(name, `/\`) || name == "." || name == ".." {
+		return "", errors.New("Cannot export name as a file name: " + name)
+	}
+	return name, nil
+}
+
+func writeExportFile(file string, data interface{}) error {
+	dataBytes, err := json.MarshalIndent(data, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, append(dataBytes, '\n'), 0600)
+}
+
+func exportMasterData(masterData *MasterData, dir string) error {
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return err
+	}
+	for _, dataNode := range masterData.DataNodes {
+		name, err := exportFileName(dataNode.Name)
+		if err != nil {
+			return err
+		}
+		dataNodeCopy := *dataNode
+		dataNodeCopy.Password = ""
+		err = writeExportFile(filepath.Join(dir, name+".datanode.json"), &dataNodeCopy)
+		if err != nil {
+			return err
+		}
+	}
+	for _, app := range masterData.Apps {
+		appName, err := exportFileName(app.Name)
+		if err != nil {
+			return err
+		}
+		appDir := filepath.Join(dir, appName)
+		err = os.MkdirAll(appDir, 0700)
+		if err != nil {
+			return err
+		}
+		appCopy := &App{}
+		err = deepCopy(app, appCopy)
+		if err != nil {
+			return err
+		}
+		// Queries and jobs share the directory of their app.
+		scripts := map[string]string{}
+		writeScript := func(name string, text string) error {
+			file := filepath.Join(appDir, name+".sql")
+			if written, ok := scripts[file]; ok {
+				if written != text {
+					return errors.New("Cannot export two different scripts to: " + file)
+				}
+				return nil
+			}
+			scripts[file] = text
+			return ioutil.WriteFile(file, []byte(text), 0644)
+		}
+		for _, query := range appCopy.Queries {
+			name, err := exportFileName(query.Name)
+			if err != nil {
+				return err
+			}
+			if query.ScriptText != "" {
+				err = writeScript(name, query.ScriptText)
+				if err != nil {
+					return err
+				}
+				query.ScriptText = ""
+			}
+		}
+		for _, job := range appCopy.Jobs {
+			name, err := exportFileName(job.Name)
+			if err != nil {
+				return err
+			}
+			if job.ScriptText != "" {
+				err = writeScript(name, job.ScriptText)
+				if err != nil {
+					return err
+				}
+				job.ScriptText = ""
+			}
+			if job.LoopScriptText != "" {
+				err = writeScript(name+"_loop", job.LoopScriptText)
+				if err != nil {
+					return err
+				}
+				job.LoopScriptText = ""
+			}
+		}
+		err = writeExportFile(filepath.Join(dir, appName+".app.json"), appCopy)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func importMasterData(dir string) (*MasterData, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	masterData := &MasterData{}
+	for _, file := range files {
+		if file.IsDir() {
+			continue
+		}
+		if strings.HasSuffix(file.Name(), ".datanode.json") {
+			dataNodeBytes, err := ioutil.ReadFile(filepath.Join(dir, file.Name()))
+			if err != nil {
+				return nil, err
+			}
+			dataNode := &DataNode{}
+			err = json.Unmarshal(dataNodeBytes, dataNode)
+			if err != nil {
+				return nil, errors.New(file.Name() + ": " + err.Error())
+			}
+			if dataNode.Id == "" {
+				dataNode.Id = newImportedId()
+			}
+			masterData.DataNodes = append(masterData.DataNodes, dataNode)
+		} else if strings.HasSuffix(file.Name(), ".app.json") {
+			appBytes, err := ioutil.ReadFile(filepath.Join(dir, file.Name()))
+			if err != nil {
+				return nil, err
+			}
+			app := &App{}
+			err = json.Unmarshal(appBytes, app)
+			if err != nil {
+				return nil, errors.New(file.Name() + ": " + err.Error())
+			}
+			err = fillImportedIds(app)
+			if err != nil {
+				return nil, errors.New(file.Name() + ": " + err.Error())
+			}
+			appDir := filepath.Join(dir, app.Name)
+			for _, query := range app.Queries {
+				text, found, err := readExportScript(appDir, query.Name)
+				if err != nil {
+					return nil, err
+				}
+				if found {
+					query.ScriptSource = ScriptSourceText
+					query.ScriptPath = ""
+					query.ScriptText = text
+				}
+			}
+			for _, job := range app.Jobs {
+				text, found, err := readExportScript(appDir, job.Name)
+				if err != nil {
+					return nil, err
+				}
+				if found {
+					job.ScriptSource = ScriptSourceText
+					job.ScriptPath = ""
+					job.ScriptText = text
+				}
+				text, found, err = readExportScript(appDir, job.Name+"_loop")
+				if err != nil {
+					return nil, err
+				}
+				if found {
+					job.LoopScriptSource = ScriptSourceText
+					job.LoopScriptPath = ""
+					job.LoopScriptText = text
+				}
+			}
+			masterData.Apps = append(masterData.Apps, app)
+		}
+	}
+	// Apps written by hand may refer to their data node by name.
+	for _, app := range masterData.Apps {
+		for _, dataNode := range masterData.DataNodes {
+			if app.DataNodeId == dataNode.Name && masterData.findDataNode(app.DataNodeId) == nil {
+				app.DataNodeId = dataNode.Id
+			}
+		}
+	}
+	return masterData, nil
+}
+
+// readExportScript reads the script <name>.sql of an app directory, if it
+// was exported.
+func readExportScript(appDir string, name string) (string, bool, error) {
+	content, err := ioutil.ReadFile(filepath.Join(appDir, name+".sql"))
+	if os.IsNotExist(err) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return string(content), true, nil
+}
+
+func newImportedId() string {
+	return strings.Replace(uuid.NewV4().String(), "-", "", -1)
+}
+
+// fillImportedIds generates the ids and the database name left out of an app
+// written by hand, and ties its entities to it.
+func fillImportedIds(app *App) error {
+	if app.Id == "" {
+		app.Id = newImportedId()
+	}
+	if app.DbName == "" {
+		dbName, err := newAppDbName(app.Name)
+		if err != nil {
+			return err
+		}
+		app.DbName = dbName
+	}
+	for _, query := range app.Queries {
+		if query.Id == "" {
+			query.Id = newImportedId()
+		}
+		query.AppId = app.Id
+	}
+	for _, job := range app.Jobs {
+		if job.Id == "" {
+			job.Id = newImportedId()
+		}
+		job.AppId = app.Id
+	}
+	for _, token := range app.Tokens {
+		if token.Id == "" {
+			// Token ids start with the id of their app.
+			token.Id = app.Id + newImportedId()
+		}
+		token.AppId = app.Id
+	}
+	for _, li := range app.LocalInterceptors {
+		if li.Id == "" {
+			li.Id = newImportedId()
+		}
+		li.AppId = app.Id
+	}
+	for _, ri := range app.RemoteInterceptors {
+		if ri.Id == "" {
+			ri.Id = newImportedId()
+		}
+		ri.AppId = app.Id
+	}
+	return nil
+}
+
+// Import applies an imported master data. Entities missing from the import
+// are kept, unless prune is set. With dryRun, the changes are only described.
+func (this *MasterData) Import(imported *MasterData, dryRun bool, prune bool) (string, error) {
+	err := imported.Validate()
+	if err != nil {
+		return "", err
+	}
+	adoptIds(this, imported)
+	// Passwords are not exported.
+	for _, dataNode := range imported.DataNodes {
+		if live := this.findDataNode(dataNode.Id); live != nil && dataNode.Password == "" {
+			dataNode.Password = live.Password
+		}
+	}
+	if !prune {
+		for _, dataNode := range this.DataNodes {
+			found := false
+			for _, v := range imported.DataNodes {
+				if v.Id == dataNode.Id {
+					found = true
+					break
+				}
+			}
+			if !found {
+				imported.DataNodes = append(imported.DataNodes, dataNode)
+			}
+		}
+		for _, app := range this.Apps {
+			importedApp := imported.findApp(app.Id)
+			if importedApp == nil {
+				imported.Apps = append(imported.Apps, app)
+				continue
+			}
+			keepMissingChildren(app, importedApp)
+		}
+	}
+	changes, err := DiffMasterData(this, imported)
+	if err != nil {
+		return "", err
+	}
+	var buffer bytes.Buffer
+	for _, change := range changes {
+		buffer.WriteString(describeChange(change, imported) + "\n")
+	}
+	if len(changes) == 0 {
+		buffer.WriteString("No changes.\n")
+	}
+	if dryRun || len(changes) == 0 {
+		return buffer.String(), nil
+	}
+	err = this.createAppDatabases(imported)
+	if err != nil {
+		return "", err
+	}
+	err = this.ApplyAndCommit(changes)
+	if err != nil {
+		return "", err
+	}
+	return buffer.String(), nil
+}
+
+// adoptIds gives the imported data nodes and apps that have the name of a live
+// one the id of the live one, so that an export taken from another master
+// updates them instead of adding duplicates.
+func adoptIds(live *MasterData, imported *MasterData) {
+	for _, dataNode := range imported.DataNodes {
+		for _, v := range live.DataNodes {
+			if v.Name == dataNode.Name && v.Id != dataNode.Id {
+				for _, app := range imported.Apps {
+					if app.DataNodeId == dataNode.Id {
+						app.DataNodeId = v.Id
+					}
+				}
+				dataNode.Id = v.Id
+			}
+		}
+	}
+	for _, app := range imported.Apps {
+		for _, v := range live.Apps {
+			if v.Name == app.Name && v.Id != app.Id {
+				app.Id = v.Id
+				app.DbName = v.DbName
+				for _, query := range app.Queries {
+					query.AppId = v.Id
+				}
+				for _, job := range app.Jobs {
+					job.AppId = v.Id
+				}
+				for _, token := range app.Tokens {
+					token.AppId = v.Id
+				}
+				for _, li := range app.LocalInterceptors {
+					li.AppId = v.Id
+				}
+				for _, ri := range app.RemoteInterceptors {
+					ri.AppId = v.Id
+				}
+			}
+		}
+	}
+}
+
+func keepMissingChildren(app *App, importedApp *App) {
+	for _, v := range app.Queries {
+		found := false
+		for _, vImported := range importedApp.Queries {
+			found = found || vImported.Id == v.Id
+		}
+		if !found {
+			importedApp.Queries = append(importedApp.Queries, v)
+		}
+	}
+	for _, v := range app.Jobs {
+		found := false
+		for _, vImported := range importedApp.Jobs {
+			found = found || vImported.Id == v.Id
+		}
+		if !found {
+			importedApp.Jobs = append(importedApp.Jobs, v)
+		}
+	}
+	for _, v := range app.Tokens {
+		found := false
+		for _, vImported := range importedApp.Tokens {
+			found = found || vImported.Id == v.Id
+		}
+		if !found {
+			importedApp.Tokens = append(importedApp.Tokens, v)
+		}
+	}
+	for _, v := range app.LocalInterceptors {
+		found := false
+		for _, vImported := range importedApp.LocalInterceptors {
+			found = found || vImported.Id == v.Id
+		}
+		if !found {
+			importedApp.LocalInterceptors = append(importedApp.LocalInterceptors, v)
+		}
+	}
+	for _, v := range app.RemoteInterceptors {
+		found := false
+		for _, vImported := range importedApp.RemoteInterceptors {
+			found = found || vImported.Id == v.Id
+		}
+		if !found {
+			importedApp.RemoteInterceptors = append(importedApp.RemoteInterceptors, v)
+		}
+	}
+}
+
+// describeChange renders a change as one line of a diff, e.g.
+// "+ Query myapp/list_users".
+func describeChange(change *MasterDataChange, imported *MasterData) string {
+	entity := &struct {
+		Id    string
+		Name  string
+		AppId string
+	}{}
+	json.Unmarshal([]byte(change.Data), entity)
+	name := entity.Name
+	if entity.AppId != "" {
+		if app := imported.findApp(entity.AppId); app != nil {
+			name = app.Name + "/" + name
+		}
+	}
+	sign := "~"
+	if change.Op == "add" {
+		sign = "+"
+	} else if change.Op == "remove" {
+		sign = "-"
+	}
+	return fmt.Sprint(sign, " ", change.Entity, " ", name, " (", entity.Id, ")")
+}
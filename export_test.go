@@ -0,0 +1,86 @@
+// export_test
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestExportImport(t *testing.T) {
+	dir := t.TempDir()
+	exported := &MasterData{
+		DataNodes: []*DataNode{{Id: "dn1", Name: "dn1", Host: "localhost", Password: "pw"}},
+		Apps: []*App{{
+			Id: "app1", Name: "app1", DbName: "nd_app1", DataNodeId: "dn1",
+			Queries: []*Query{
+				{Id: "q1", Name: "list", AppId: "app1", ScriptSource: ScriptSourcePath, ScriptPath: "/srv/list.sql", ScriptText: "select * from t"},
+			},
+			Jobs: []*Job{
+				{Id: "j1", Name: "purge", AppId: "app1", ScriptSource: ScriptSourcePath, ScriptPath: "/srv/purge.sql",
+					ScriptText: "delete from t where id=$0", LoopScriptText: "select id from t"},
+				{Id: "j2", Name: "idle", AppId: "app1", ScriptSource: ScriptSourcePath, ScriptPath: "/srv/idle.sql"},
+			},
+		}},
+	}
+	err := exportMasterData(exported, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The scripts are where Query.Reload and Job.Reload look for them.
+	for file, want := range map[string]string{
+		"list.sql":       "select * from t",
+		"purge.sql":      "delete from t where id=$0",
+		"purge_loop.sql": "select id from t",
+	} {
+		content, err := ioutil.ReadFile(filepath.Join(dir, "app1", file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(content) != want {
+			t.Errorf("%v holds %q, want %q", file, content, want)
+		}
+	}
+
+	imported, err := importMasterData(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(imported.DataNodes) != 1 || imported.DataNodes[0].Password != "" {
+		t.Errorf("imported data nodes %+v, want one without password", imported.DataNodes)
+	}
+	if len(imported.Apps) != 1 || len(imported.Apps[0].Queries) != 1 || len(imported.Apps[0].Jobs) != 2 {
+		t.Fatalf("imported apps %+v", imported.Apps)
+	}
+	query := imported.Apps[0].Queries[0]
+	if query.ScriptSource != ScriptSourceText || query.ScriptPath != "" || query.ScriptText != "select * from t" {
+		t.Errorf("imported query %+v, want its script as text", query)
+	}
+	job := imported.Apps[0].Jobs[0]
+	if job.ScriptSource != ScriptSourceText || job.ScriptPath != "" || job.ScriptText != "delete from t where id=$0" ||
+		job.LoopScriptSource != ScriptSourceText || job.LoopScriptText != "select id from t" {
+		t.Errorf("imported job %+v, want its scripts as text", job)
+	}
+	// A job exported without script text keeps reading its script from its path.
+	idle := imported.Apps[0].Jobs[1]
+	if idle.ScriptSource != ScriptSourcePath || idle.ScriptPath != "/srv/idle.sql" || idle.ScriptText != "" {
+		t.Errorf("imported job %+v, want it left on its path", idle)
+	}
+}
+
+func TestExportScriptCollision(t *testing.T) {
+	masterData := &MasterData{
+		Apps: []*App{{
+			Id: "app1", Name: "app1",
+			Queries: []*Query{{Id: "q1", Name: "purge", AppId: "app1", ScriptText: "select 1"}},
+			Jobs:    []*Job{{Id: "j1", Name: "purge", AppId: "app1", ScriptText: "delete from t"}},
+		}},
+	}
+	if exportMasterData(masterData, t.TempDir()) == nil {
+		t.Error("exported a query and a job with different scripts to the same file")
+	}
+	masterData.Apps[0].Jobs[0].ScriptText = "select 1"
+	if err := exportMasterData(masterData, t.TempDir()); err != nil {
+		t.Errorf("export of a query and a job sharing their script failed: %v", err)
+	}
+}
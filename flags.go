@@ -10,23 +10,51 @@ import (
 )
 
 type CliService struct {
-	Id           string
-	Master       string
-	EnableHttp   bool // true
-	HttpPort     int
-	HttpHost     string // "127.0.0.1"
-	EnableHttps  bool
-	HttpsPort    int
-	HttpsHost    string
-	CertFile     string
-	KeyFile      string
-	ConfFile     string
-	DataFile     string
-	Secret       string
-	MailHost     string
-	MailPort     int
-	MailUsername string
-	MailPassword string
+	Id             string
+	Master         string
+	Standby        bool
+	Peer           string
+	LeaseTimeout   int
+	PingInterval   int
+	PongTimeout    int
+	ReconnectMax   int
+	EnableHttp     bool // true
+	HttpPort       int
+	HttpHost       string // "127.0.0.1"
+	EnableHttps    bool
+	HttpsPort      int
+	HttpsHost      string
+	CertFile       string
+	KeyFile        string
+	CaFile         string
+	ClientCertFile string
+	ClientKeyFile  string
+	Insecure       bool
+	ConfFile       string
+	DataFile       string
+	CacheFile      string
+	DataStore      string
+	DataStoreDs    string
+	JournalFile    string
+	CompactEvery   int
+	HistoryDir     string
+	HistorySize    int
+	LogFile        string
+	StatsInterval  int
+	SendQueueSize  int
+	WriteTimeout   int
+	ServerName     string
+	ServerIP4      string
+	ServerIP6      string
+	ServerPort     int
+	CountryCode    string
+	Region         string
+	SuperRegion    string
+	Secret         string
+	MailHost       string
+	MailPort       int
+	MailUsername   string
+	MailPassword   string
 }
 
 func (this *CliService) Flags() []cli.Flag {
@@ -38,9 +66,43 @@ func (this *CliService) Flags() []cli.Flag {
 		},
 		cli.StringFlag{
 			Name:        "master, m",
-			Usage:       "master node url, format: host:port. master if empty",
+			Usage:       "master node urls, format: host:port[,host:port]. master if empty",
 			Destination: &this.Master,
 		},
+		cli.BoolFlag{
+			Name:        "standby",
+			Usage:       "start as a standby master, following the peer until its lease expires",
+			Destination: &this.Standby,
+		},
+		cli.StringFlag{
+			Name:        "peer",
+			Usage:       "url of the other master node of a primary and standby pair, format: host:port",
+			Destination: &this.Peer,
+		},
+		cli.IntFlag{
+			Name:        "lease_timeout",
+			Value:       15,
+			Usage:       "seconds a standby waits for the master to renew its lease before promoting itself, a master fences itself after half of it without acknowledgement from its standby",
+			Destination: &this.LeaseTimeout,
+		},
+		cli.IntFlag{
+			Name:        "ping_interval",
+			Value:       10,
+			Usage:       "seconds between pings on the master slave web socket, 0 to disable heartbeats",
+			Destination: &this.PingInterval,
+		},
+		cli.IntFlag{
+			Name:        "pong_timeout",
+			Value:       30,
+			Usage:       "seconds without a pong after which the master slave web socket is considered dead",
+			Destination: &this.PongTimeout,
+		},
+		cli.IntFlag{
+			Name:        "reconnect_max",
+			Value:       60,
+			Usage:       "maximum seconds a slave waits between attempts to reconnect to the master",
+			Destination: &this.ReconnectMax,
+		},
 		cli.IntFlag{
 			Name:        "http_port, P",
 			Value:       1103,
@@ -74,6 +136,26 @@ func (this *CliService) Flags() []cli.Flag {
 			Usage:       "key file path, search path: ~/.netdata/key.key, /etc/netdata/key.key",
 			Destination: &this.KeyFile,
 		},
+		cli.StringFlag{
+			Name:        "ca_file",
+			Usage:       "cluster CA file path, to verify the certificates of the master, slaves and cli",
+			Destination: &this.CaFile,
+		},
+		cli.StringFlag{
+			Name:        "client_cert_file",
+			Usage:       "client cert file path, presented to the master by slaves and the cli",
+			Destination: &this.ClientCertFile,
+		},
+		cli.StringFlag{
+			Name:        "client_key_file",
+			Usage:       "client key file path",
+			Destination: &this.ClientKeyFile,
+		},
+		cli.BoolFlag{
+			Name:        "insecure",
+			Usage:       "skip the verification of certificates, for testing only",
+			Destination: &this.Insecure,
+		},
 		cli.StringFlag{
 			Name:        "conf_file, C",
 			Usage:       "configuration file path, search path: ~/.netdata/netdata.json, /etc/netdata/netdata.json",
@@ -85,6 +167,105 @@ func (this *CliService) Flags() []cli.Flag {
 			Usage:       "master data file path, ignored by slave nodes, search path: ~/.netdata/netdata_master.json",
 			Destination: &this.DataFile,
 		},
+		cli.StringFlag{
+			Name:        "cache_file",
+			Value:       homeDir + "/.netdata/netdata_slave_cache.json",
+			Usage:       "file where slave nodes cache the last master data received, to boot from while the master is unreachable",
+			Destination: &this.CacheFile,
+		},
+		cli.StringFlag{
+			Name:        "data_store, D",
+			Value:       "file",
+			Usage:       "where the master keeps its data: file, sqlite or mysql",
+			Destination: &this.DataStore,
+		},
+		cli.StringFlag{
+			Name:        "data_store_ds",
+			Usage:       "data source of the data store, sqlite file path or mysql user:pass@tcp(host:port)/db, ignored by the file data store",
+			Destination: &this.DataStoreDs,
+		},
+		cli.StringFlag{
+			Name:        "journal_file, j",
+			Value:       homeDir + "/.netdata/netdata_master.journal",
+			Usage:       "master data journal file path, ignored by slave nodes and by the sqlite and mysql data stores, which keep the journal in their database, search path: ~/.netdata/netdata_master.journal",
+			Destination: &this.JournalFile,
+		},
+		cli.IntFlag{
+			Name:        "compact_every",
+			Value:       300,
+			Usage:       "seconds between compactions of the journal into the master data file, 0 to compact on shutdown only",
+			Destination: &this.CompactEvery,
+		},
+		cli.StringFlag{
+			Name:        "history_dir",
+			Value:       homeDir + "/.netdata/history",
+			Usage:       "directory to keep previous versions of the master data in, ignored by slave nodes",
+			Destination: &this.HistoryDir,
+		},
+		cli.IntFlag{
+			Name:        "history_size",
+			Value:       20,
+			Usage:       "number of previous versions of the master data to keep for rollback",
+			Destination: &this.HistorySize,
+		},
+		cli.StringFlag{
+			Name:        "server_name",
+			Usage:       "public host name of this api node",
+			Destination: &this.ServerName,
+		},
+		cli.StringFlag{
+			Name:        "server_ip4",
+			Usage:       "public ipv4 address of this api node",
+			Destination: &this.ServerIP4,
+		},
+		cli.StringFlag{
+			Name:        "server_ip6",
+			Usage:       "public ipv6 address of this api node",
+			Destination: &this.ServerIP6,
+		},
+		cli.IntFlag{
+			Name:        "server_port",
+			Usage:       "public port of this api node, the https or http port if 0",
+			Destination: &this.ServerPort,
+		},
+		cli.StringFlag{
+			Name:        "country_code",
+			Usage:       "country code of this api node, e.g. US",
+			Destination: &this.CountryCode,
+		},
+		cli.StringFlag{
+			Name:        "region",
+			Usage:       "region label of this api node, e.g. us-east",
+			Destination: &this.Region,
+		},
+		cli.StringFlag{
+			Name:        "super_region",
+			Usage:       "super region label of this api node, e.g. americas",
+			Destination: &this.SuperRegion,
+		},
+		cli.IntFlag{
+			Name:        "stats_interval",
+			Value:       30,
+			Usage:       "seconds between pushes of request statistics to the master, 0 to disable",
+			Destination: &this.StatsInterval,
+		},
+		cli.IntFlag{
+			Name:        "send_queue_size",
+			Value:       64,
+			Usage:       "messages queued for a slave before it is evicted as too slow",
+			Destination: &this.SendQueueSize,
+		},
+		cli.IntFlag{
+			Name:        "write_timeout",
+			Value:       10,
+			Usage:       "seconds to write a message to a slave before it is evicted as too slow",
+			Destination: &this.WriteTimeout,
+		},
+		cli.StringFlag{
+			Name:        "log_file",
+			Usage:       "log file path, stderr if empty",
+			Destination: &this.LogFile,
+		},
 		cli.StringFlag{
 			Name:        "secret, z",
 			Usage:       "secret password for server client communication.",
@@ -93,6 +274,32 @@ func (this *CliService) Flags() []cli.Flag {
 	}
 }
 
+// TlsFlags are the global flags of the cli to connect to the master.
+func (this *CliService) TlsFlags() []cli.Flag {
+	return []cli.Flag{
+		cli.StringFlag{
+			Name:        "ca_file",
+			Usage:       "cluster CA file path, to verify the certificates of the master, slaves and cli",
+			Destination: &this.CaFile,
+		},
+		cli.StringFlag{
+			Name:        "client_cert_file",
+			Usage:       "client cert file path, presented to the master by slaves and the cli",
+			Destination: &this.ClientCertFile,
+		},
+		cli.StringFlag{
+			Name:        "client_key_file",
+			Usage:       "client key file path",
+			Destination: &this.ClientKeyFile,
+		},
+		cli.BoolFlag{
+			Name:        "insecure",
+			Usage:       "skip the verification of certificates, for testing only",
+			Destination: &this.Insecure,
+		},
+	}
+}
+
 func (this *CliService) LoadConfigs(c *cli.Context) {
 	this.LoadConfig("/etc/netdata/netdata.json", c)
 	this.LoadConfig(homeDir+"/.netdata/netdata.json", c)
@@ -103,11 +310,12 @@ func (this *CliService) LoadConfigs(c *cli.Context) {
 	}
 }
 
-func (this *CliService) LoadSecrets(c *cli.Context) {
+func (this *CliService) LoadSecrets(c *cli.Context) error {
 	this.LoadSecret("/etc/netdata/netdata.json", c)
 	this.LoadSecret(homeDir+"/.netdata/netdata.json", c)
 	this.LoadSecret(pwd+"/netdata.json", c)
 	this.LoadSecret(this.ConfFile, c)
+	return this.LoadContext(c)
 }
 
 func (this *CliService) LoadConfig(file string, c *cli.Context) {
@@ -128,6 +336,42 @@ func (this *CliService) LoadConfig(file string, c *cli.Context) {
 			this.Master = v
 		}
 	}
+	if !c.IsSet("standby") {
+		v, err := jqConf.QueryToBool("standby")
+		if err == nil {
+			this.Standby = v
+		}
+	}
+	if !c.IsSet("peer") {
+		v, err := jqConf.QueryToString("peer")
+		if err == nil {
+			this.Peer = v
+		}
+	}
+	if !c.IsSet("lease_timeout") {
+		v, err := jqConf.QueryToInt64("lease_timeout")
+		if err == nil {
+			this.LeaseTimeout = int(v)
+		}
+	}
+	if !c.IsSet("ping_interval") {
+		v, err := jqConf.QueryToInt64("ping_interval")
+		if err == nil {
+			this.PingInterval = int(v)
+		}
+	}
+	if !c.IsSet("pong_timeout") {
+		v, err := jqConf.QueryToInt64("pong_timeout")
+		if err == nil {
+			this.PongTimeout = int(v)
+		}
+	}
+	if !c.IsSet("reconnect_max") {
+		v, err := jqConf.QueryToInt64("reconnect_max")
+		if err == nil {
+			this.ReconnectMax = int(v)
+		}
+	}
 	if !c.IsSet("http_port") {
 		v, err := jqConf.QueryToInt64("http_port")
 		if err == nil {
@@ -170,6 +414,30 @@ func (this *CliService) LoadConfig(file string, c *cli.Context) {
 			this.KeyFile = v
 		}
 	}
+	if !c.IsSet("ca_file") && !c.GlobalIsSet("ca_file") {
+		v, err := jqConf.QueryToString("ca_file")
+		if err == nil {
+			this.CaFile = v
+		}
+	}
+	if !c.IsSet("client_cert_file") && !c.GlobalIsSet("client_cert_file") {
+		v, err := jqConf.QueryToString("client_cert_file")
+		if err == nil {
+			this.ClientCertFile = v
+		}
+	}
+	if !c.IsSet("client_key_file") && !c.GlobalIsSet("client_key_file") {
+		v, err := jqConf.QueryToString("client_key_file")
+		if err == nil {
+			this.ClientKeyFile = v
+		}
+	}
+	if !c.IsSet("insecure") && !c.GlobalIsSet("insecure") {
+		v, err := jqConf.QueryToBool("insecure")
+		if err == nil {
+			this.Insecure = v
+		}
+	}
 	if !c.IsSet("conf_file") {
 		v, err := jqConf.QueryToString("conf_file")
 		if err == nil {
@@ -182,6 +450,114 @@ func (this *CliService) LoadConfig(file string, c *cli.Context) {
 			this.DataFile = v
 		}
 	}
+	if !c.IsSet("cache_file") {
+		v, err := jqConf.QueryToString("cache_file")
+		if err == nil {
+			this.CacheFile = v
+		}
+	}
+	if !c.IsSet("data_store") {
+		v, err := jqConf.QueryToString("data_store")
+		if err == nil {
+			this.DataStore = v
+		}
+	}
+	if !c.IsSet("data_store_ds") {
+		v, err := jqConf.QueryToString("data_store_ds")
+		if err == nil {
+			this.DataStoreDs = v
+		}
+	}
+	if !c.IsSet("journal_file") {
+		v, err := jqConf.QueryToString("journal_file")
+		if err == nil {
+			this.JournalFile = v
+		}
+	}
+	if !c.IsSet("compact_every") {
+		v, err := jqConf.QueryToInt64("compact_every")
+		if err == nil {
+			this.CompactEvery = int(v)
+		}
+	}
+	if !c.IsSet("history_dir") {
+		v, err := jqConf.QueryToString("history_dir")
+		if err == nil {
+			this.HistoryDir = v
+		}
+	}
+	if !c.IsSet("history_size") {
+		v, err := jqConf.QueryToInt64("history_size")
+		if err == nil {
+			this.HistorySize = int(v)
+		}
+	}
+	if !c.IsSet("server_name") {
+		v, err := jqConf.QueryToString("server_name")
+		if err == nil {
+			this.ServerName = v
+		}
+	}
+	if !c.IsSet("server_ip4") {
+		v, err := jqConf.QueryToString("server_ip4")
+		if err == nil {
+			this.ServerIP4 = v
+		}
+	}
+	if !c.IsSet("server_ip6") {
+		v, err := jqConf.QueryToString("server_ip6")
+		if err == nil {
+			this.ServerIP6 = v
+		}
+	}
+	if !c.IsSet("server_port") {
+		v, err := jqConf.QueryToInt64("server_port")
+		if err == nil {
+			this.ServerPort = int(v)
+		}
+	}
+	if !c.IsSet("country_code") {
+		v, err := jqConf.QueryToString("country_code")
+		if err == nil {
+			this.CountryCode = v
+		}
+	}
+	if !c.IsSet("region") {
+		v, err := jqConf.QueryToString("region")
+		if err == nil {
+			this.Region = v
+		}
+	}
+	if !c.IsSet("super_region") {
+		v, err := jqConf.QueryToString("super_region")
+		if err == nil {
+			this.SuperRegion = v
+		}
+	}
+	if !c.IsSet("stats_interval") {
+		v, err := jqConf.QueryToInt64("stats_interval")
+		if err == nil {
+			this.StatsInterval = int(v)
+		}
+	}
+	if !c.IsSet("send_queue_size") {
+		v, err := jqConf.QueryToInt64("send_queue_size")
+		if err == nil {
+			this.SendQueueSize = int(v)
+		}
+	}
+	if !c.IsSet("write_timeout") {
+		v, err := jqConf.QueryToInt64("write_timeout")
+		if err == nil {
+			this.WriteTimeout = int(v)
+		}
+	}
+	if !c.IsSet("log_file") {
+		v, err := jqConf.QueryToString("log_file")
+		if err == nil {
+			this.LogFile = v
+		}
+	}
 	if !c.IsSet("secret") {
 		v, err := jqConf.QueryToString("secret")
 		if err == nil {
@@ -220,6 +596,30 @@ func (this *CliService) LoadSecret(file string, c *cli.Context) {
 		//ignore
 		return
 	}
+	if !c.IsSet("ca_file") && !c.GlobalIsSet("ca_file") {
+		v, err := jqConf.QueryToString("ca_file")
+		if err == nil {
+			this.CaFile = v
+		}
+	}
+	if !c.IsSet("client_cert_file") && !c.GlobalIsSet("client_cert_file") {
+		v, err := jqConf.QueryToString("client_cert_file")
+		if err == nil {
+			this.ClientCertFile = v
+		}
+	}
+	if !c.IsSet("client_key_file") && !c.GlobalIsSet("client_key_file") {
+		v, err := jqConf.QueryToString("client_key_file")
+		if err == nil {
+			this.ClientKeyFile = v
+		}
+	}
+	if !c.IsSet("insecure") && !c.GlobalIsSet("insecure") {
+		v, err := jqConf.QueryToBool("insecure")
+		if err == nil {
+			this.Insecure = v
+		}
+	}
 	if !c.IsSet("secret") {
 		v, err := jqConf.QueryToString("secret")
 		if err == nil {
@@ -50,27 +50,24 @@ func checkAccessPermission(targets, tableId, mode, op string) bool {
 func checkProjectToken(context map[string]interface{}, tableId string, op string) error {
 
 	token := context["api_token"].(string)
+	index := getMasterIndex()
 	if _, ok := context["app"]; !ok {
 		appId := context["app_id"].(string)
-		for _, a := range masterData.Apps {
-			if a.Id == appId {
-				context["app"] = a
-				break
-			}
+		app, err := index.App(appId)
+		if err != nil {
+			return err
 		}
+		context["app"] = app
 	}
 
 	app := context["app"].(*App)
 
-	for _, t := range app.Tokens {
-		if t.AppId == app.Id && t.Id == token {
-			if checkAccessPermission(t.Target, tableId, t.Mode, op) {
-				return nil
-			} else {
-				return errors.New("Authentication failed.")
-			}
-			break
-		}
+	t, err := index.Token(app.Id, token)
+	if err != nil {
+		return errors.New("Authentication failed.")
+	}
+	if checkAccessPermission(t.Target, tableId, t.Mode, op) {
+		return nil
 	}
 	return errors.New("Authentication failed.")
 }
@@ -202,19 +199,10 @@ func (this *GlobalTokenInterceptor) AfterListArray(resourceId string, db *sql.DB
 }
 func (this *GlobalTokenInterceptor) BeforeExec(resourceId string, script string, params *[][]interface{}, queryParams map[string]string, array bool, db *sql.DB, context map[string]interface{}) error {
 	if appId, ok := context["app_id"].(string); ok {
-		for iApp, _ := range masterData.Apps {
-			if masterData.Apps[iApp].Id == appId {
-				for _, vQuery := range masterData.Apps[iApp].Queries {
-					if vQuery.Name == resourceId && vQuery.AppId == appId {
-						if vQuery.Mode != "public" {
-							err := checkUserToken(context)
-							if err != nil {
-								return err
-							}
-						}
-						break
-					}
-				}
+		if query, err := getMasterIndex().Query(appId, resourceId); err == nil && query.Mode != "public" {
+			err := checkUserToken(context)
+			if err != nil {
+				return err
 			}
 		}
 	}
@@ -0,0 +1,22 @@
+module github.com/elgs/netdata
+
+go 1.24.0
+
+require (
+	github.com/dvsekhvalnov/jose2go v1.11.0
+	github.com/elgs/gojq v0.0.0-20160421194050-81fa9a608a13
+	github.com/elgs/gosplitargs v0.0.0-20161028071935-a491c5eeb3c8
+	github.com/elgs/gostrgen v0.0.0-20220325073726-0c3e00d082f6
+	github.com/go-sql-driver/mysql v1.10.1
+	github.com/gorilla/websocket v1.5.3
+	github.com/mattn/go-sqlite3 v1.14.52
+	github.com/satori/go.uuid v1.2.0
+	github.com/urfave/cli v1.22.17
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require (
+	filippo.io/edwards25519 v1.2.0 // indirect
+	github.com/cpuguy83/go-md2man/v2 v2.0.7 // indirect
+	github.com/russross/blackfriday/v2 v2.1.0 // indirect
+)
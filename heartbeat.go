@@ -0,0 +1,106 @@
+// heartbeat
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func init() {
+	// Different slaves must draw different jitters.
+	rand.Seed(time.Now().UnixNano())
+}
+
+// checkHeartbeat rejects a pong timeout that does not leave a pong, sent back
+// once per ping interval, the time to arrive.
+func checkHeartbeat() error {
+	if service.PingInterval > 0 && service.PongTimeout > 0 && service.PongTimeout <= service.PingInterval {
+		return errors.New(fmt.Sprint("Pong timeout must be longer than the ping interval, pong timeout: ", service.PongTimeout, ", ping interval: ", service.PingInterval))
+	}
+	return nil
+}
+
+// keepAlive pings the other end of conn every PingInterval, and lets reads on
+// conn fail once no pong, nor any other message, has arrived for PongTimeout,
+// so that half open connections are detected and dropped. It is called before
+// the read loop on conn starts, which runs the pong handler and extends the
+// deadline, and it stops pinging when done is closed.
+func keepAlive(conn *websocket.Conn, done chan bool) {
+	pingInterval := time.Duration(service.PingInterval) * time.Second
+	pongTimeout := time.Duration(service.PongTimeout) * time.Second
+	if pingInterval <= 0 || pongTimeout <= 0 {
+		return
+	}
+	conn.SetReadDeadline(time.Now().Add(pongTimeout))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(pongTimeout))
+	})
+	go func() {
+		ticker := time.NewTicker(pingInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(pingInterval))
+				if err != nil {
+					log.Println(conn.RemoteAddr(), "ping failed:", err)
+				}
+			}
+		}
+	}()
+}
+
+// extendReadDeadline counts a message as a sign of life, like a pong.
+func extendReadDeadline(conn *websocket.Conn) {
+	if service.PingInterval > 0 && service.PongTimeout > 0 {
+		conn.SetReadDeadline(time.Now().Add(time.Duration(service.PongTimeout) * time.Second))
+	}
+}
+
+// Backoff computes exponentially growing delays with jitter between
+// reconnection attempts, so that slaves do not all reconnect to a restarting
+// master at once.
+type Backoff struct {
+	Min     time.Duration
+	Max     time.Duration
+	mutex   *sync.Mutex
+	attempt uint
+}
+
+func NewBackoff(min time.Duration, max time.Duration) *Backoff {
+	return &Backoff{
+		Min:   min,
+		Max:   max,
+		mutex: &sync.Mutex{},
+	}
+}
+
+// Next returns a random delay between half and all of min * 2^attempts,
+// capped at max.
+func (this *Backoff) Next() time.Duration {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	delay := this.Max
+	if this.attempt < 32 && this.Min<<this.attempt < this.Max {
+		delay = this.Min << this.attempt
+	}
+	this.attempt++
+	if delay <= 0 {
+		return 0
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+func (this *Backoff) Reset() {
+	this.mutex.Lock()
+	this.attempt = 0
+	this.mutex.Unlock()
+}
@@ -0,0 +1,62 @@
+// heartbeat_test
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	backoff := NewBackoff(time.Second, 10*time.Second)
+	ceilings := []time.Duration{1, 2, 4, 8, 10, 10}
+	for i, ceiling := range ceilings {
+		ceiling *= time.Second
+		delay := backoff.Next()
+		if delay < ceiling/2 || delay > ceiling {
+			t.Fatalf("attempt %v waits %v, want between %v and %v", i, delay, ceiling/2, ceiling)
+		}
+	}
+	backoff.Reset()
+	if delay := backoff.Next(); delay > time.Second {
+		t.Errorf("first attempt after reset waits %v, want at most 1s", delay)
+	}
+
+	// Shifting min past the width of a duration must not wrap around.
+	backoff = NewBackoff(time.Hour, 24*time.Hour)
+	backoff.attempt = 70
+	if delay := backoff.Next(); delay < 12*time.Hour || delay > 24*time.Hour {
+		t.Errorf("attempt 70 waits %v, want it capped at 24h", delay)
+	}
+
+	if delay := NewBackoff(0, 0).Next(); delay != 0 {
+		t.Errorf("zero backoff waits %v", delay)
+	}
+}
+
+func TestCheckHeartbeat(t *testing.T) {
+	pingInterval, pongTimeout := service.PingInterval, service.PongTimeout
+	defer func() {
+		service.PingInterval, service.PongTimeout = pingInterval, pongTimeout
+	}()
+	tests := []struct {
+		name         string
+		pingInterval int
+		pongTimeout  int
+		wantErr      bool
+	}{
+		{"timeout above interval", 10, 30, false},
+		{"timeout equal to interval", 10, 10, true},
+		{"timeout below interval", 30, 10, true},
+		{"heartbeats off", 0, 10, false},
+		{"timeout off", 10, 0, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			service.PingInterval, service.PongTimeout = test.pingInterval, test.pongTimeout
+			err := checkHeartbeat()
+			if (err != nil) != test.wantErr {
+				t.Errorf("err = %v, want error %v", err, test.wantErr)
+			}
+		})
+	}
+}
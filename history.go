@@ -0,0 +1,471 @@
+// history
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MasterDataVersion is a snapshot of the master data kept for rollback.
+type MasterDataVersion struct {
+	Version     int64
+	Time        int64
+	CommandType string
+	MasterData  *MasterData `json:",omitempty"`
+}
+
+// MasterDataHistory keeps the last Size versions of the master data, one file
+// per version in Dir.
+type MasterDataHistory struct {
+	Dir      string
+	Size     int
+	mutex    *sync.Mutex
+	versions []*MasterDataVersion
+}
+
+var masterHistory *MasterDataHistory
+
+func OpenMasterDataHistory(dir string, size int) (*MasterDataHistory, error) {
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, err
+	}
+	this := &MasterDataHistory{
+		Dir:   dir,
+		Size:  size,
+		mutex: &sync.Mutex{},
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+		version := &MasterDataVersion{}
+		versionBytes, err := ioutil.ReadFile(filepath.Join(dir, file.Name()))
+		if err != nil {
+			return nil, err
+		}
+		err = json.Unmarshal(versionBytes, version)
+		if err != nil {
+			log.Println("Ignoring history file:", file.Name(), err)
+			continue
+		}
+		version.MasterData = nil
+		this.versions = append(this.versions, version)
+	}
+	sort.Sort(byVersion(this.versions))
+	return this, nil
+}
+
+func (this *MasterDataHistory) file(version int64) string {
+	return filepath.Join(this.Dir, fmt.Sprint(version, ".json"))
+}
+
+// Record saves a snapshot of masterData, tagged with the type of the command
+// that produced it, and drops the oldest snapshots beyond Size.
+func (this *MasterDataHistory) Record(masterData *MasterData, commandType string) error {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	masterDataMutex.Lock()
+	masterDataCopy := &MasterData{}
+	err := deepCopy(masterData, masterDataCopy)
+	masterDataMutex.Unlock()
+	if err != nil {
+		return err
+	}
+	for _, v := range this.versions {
+		if v.Version == masterDataCopy.Version {
+			return nil
+		}
+	}
+	version := &MasterDataVersion{
+		Version:     masterDataCopy.Version,
+		Time:        time.Now().Unix(),
+		CommandType: commandType,
+		MasterData:  masterDataCopy,
+	}
+	versionBytes, err := json.Marshal(version)
+	if err != nil {
+		return err
+	}
+	err = writeFileAtomic(this.file(version.Version), versionBytes, 0600)
+	if err != nil {
+		return err
+	}
+	version.MasterData = nil
+	this.versions = append(this.versions, version)
+	for len(this.versions) > this.Size && len(this.versions) > 1 {
+		err = os.Remove(this.file(this.versions[0].Version))
+		if err != nil && !os.IsNotExist(err) {
+			log.Println(err)
+		}
+		this.versions = this.versions[1:]
+	}
+	return nil
+}
+
+func (this *MasterDataHistory) List() string {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	var buffer bytes.Buffer
+	for i := len(this.versions) - 1; i >= 0; i-- {
+		v := this.versions[i]
+		buffer.WriteString(fmt.Sprintln(v.Version, time.Unix(v.Time, 0).Format(time.RFC3339), v.CommandType))
+	}
+	return buffer.String()
+}
+
+func (this *MasterDataHistory) Load(version int64) (*MasterData, error) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	for _, v := range this.versions {
+		if v.Version == version {
+			versionBytes, err := ioutil.ReadFile(this.file(version))
+			if err != nil {
+				return nil, err
+			}
+			masterDataVersion := &MasterDataVersion{}
+			err = json.Unmarshal(versionBytes, masterDataVersion)
+			if err != nil {
+				return nil, err
+			}
+			if masterDataVersion.MasterData == nil {
+				return nil, errors.New("Empty master data version: " + strconv.FormatInt(version, 10))
+			}
+			return masterDataVersion.MasterData, nil
+		}
+	}
+	return nil, errors.New("Master data version not found: " + strconv.FormatInt(version, 10))
+}
+
+type byVersion []*MasterDataVersion
+
+func (this byVersion) Len() int           { return len(this) }
+func (this byVersion) Swap(i, j int)      { this[i], this[j] = this[j], this[i] }
+func (this byVersion) Less(i, j int) bool { return this[i].Version < this[j].Version }
+
+// Rollback restores the data nodes and apps of the given version, as a new
+// version of the master data. Databases of apps that did not exist in the
+// restored version are left in place.
+func (this *MasterData) Rollback(version int64) error {
+	target, err := masterHistory.Load(version)
+	if err != nil {
+		return err
+	}
+	changes, err := DiffMasterData(this, target)
+	if err != nil {
+		return err
+	}
+	if len(changes) == 0 {
+		return errors.New("Nothing to roll back, master data is identical to version: " + strconv.FormatInt(version, 10))
+	}
+	err = this.createAppDatabases(target)
+	if err != nil {
+		return err
+	}
+	return this.ApplyAndCommit(changes)
+}
+
+// ApplyAndCommit commits changes computed outside the Add*, Update* and
+// Remove* functions as a new version, and takes care of the runtime state of
+// the jobs they touch.
+func (this *MasterData) ApplyAndCommit(changes []*MasterDataChange) error {
+	existingJobs := map[string]bool{}
+	startedJobs := []*Job{}
+	for _, app := range this.Apps {
+		for _, job := range app.Jobs {
+			existingJobs[job.Id] = job.Started()
+			if job.Started() {
+				startedJobs = append(startedJobs, job)
+			}
+		}
+	}
+	err := this.Commit(changes...)
+	if err != nil {
+		return err
+	}
+	for _, job := range startedJobs {
+		err := job.Stop()
+		if err != nil {
+			log.Println(err)
+		}
+	}
+	for _, app := range this.Apps {
+		for _, job := range app.Jobs {
+			started, existed := existingJobs[job.Id]
+			if started || (!existed && job.AutoStart == 1) {
+				err := job.Start()
+				if err != nil {
+					log.Println(err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// DiffMasterData returns the changes that turn from into to.
+func DiffMasterData(from *MasterData, to *MasterData) ([]*MasterDataChange, error) {
+	changes := []*MasterDataChange{}
+	add := func(entity string, op string, data interface{}) error {
+		change, err := NewMasterDataChange(entity, op, data)
+		if err != nil {
+			return err
+		}
+		changes = append(changes, change)
+		return nil
+	}
+
+	for _, vFrom := range from.DataNodes {
+		found := false
+		for _, vTo := range to.DataNodes {
+			if vTo.Id == vFrom.Id {
+				found = true
+				if !reflect.DeepEqual(vFrom, vTo) {
+					if err := add("DataNode", "update", vTo); err != nil {
+						return nil, err
+					}
+				}
+				break
+			}
+		}
+		if !found {
+			if err := add("DataNode", "remove", vFrom); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for _, vTo := range to.DataNodes {
+		found := false
+		for _, vFrom := range from.DataNodes {
+			if vTo.Id == vFrom.Id {
+				found = true
+				break
+			}
+		}
+		if !found {
+			if err := add("DataNode", "add", vTo); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	for _, vFrom := range from.Apps {
+		if to.findApp(vFrom.Id) == nil {
+			err := add("App", "remove", vFrom)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	for _, vTo := range to.Apps {
+		vFrom := from.findApp(vTo.Id)
+		if vFrom == nil {
+			err := add("App", "add", vTo)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if vFrom.Name != vTo.Name || vFrom.DbName != vTo.DbName || vFrom.DataNodeId != vTo.DataNodeId || vFrom.Note != vTo.Note || vFrom.Status != vTo.Status {
+			err := add("App", "update", vTo)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		err := diffApp(vFrom, vTo, add)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return changes, nil
+}
+
+func diffApp(from *App, to *App, add func(entity string, op string, data interface{}) error) error {
+	for _, vFrom := range from.Queries {
+		found := false
+		for _, vTo := range to.Queries {
+			if vTo.Id == vFrom.Id {
+				found = true
+				if !reflect.DeepEqual(vFrom, vTo) {
+					if err := add("Query", "update", vTo); err != nil {
+						return err
+					}
+				}
+				break
+			}
+		}
+		if !found {
+			if err := add("Query", "remove", vFrom); err != nil {
+				return err
+			}
+		}
+	}
+	for _, vTo := range to.Queries {
+		found := false
+		for _, vFrom := range from.Queries {
+			if vTo.Id == vFrom.Id {
+				found = true
+				break
+			}
+		}
+		if !found {
+			if err := add("Query", "add", vTo); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, vFrom := range from.Jobs {
+		found := false
+		for _, vTo := range to.Jobs {
+			if vTo.Id == vFrom.Id {
+				found = true
+				if !reflect.DeepEqual(vFrom, vTo) {
+					if err := add("Job", "update", vTo); err != nil {
+						return err
+					}
+				}
+				break
+			}
+		}
+		if !found {
+			if err := add("Job", "remove", vFrom); err != nil {
+				return err
+			}
+		}
+	}
+	for _, vTo := range to.Jobs {
+		found := false
+		for _, vFrom := range from.Jobs {
+			if vTo.Id == vFrom.Id {
+				found = true
+				break
+			}
+		}
+		if !found {
+			if err := add("Job", "add", vTo); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, vFrom := range from.Tokens {
+		found := false
+		for _, vTo := range to.Tokens {
+			if vTo.Id == vFrom.Id {
+				found = true
+				if !reflect.DeepEqual(vFrom, vTo) {
+					if err := add("Token", "update", vTo); err != nil {
+						return err
+					}
+				}
+				break
+			}
+		}
+		if !found {
+			if err := add("Token", "remove", vFrom); err != nil {
+				return err
+			}
+		}
+	}
+	for _, vTo := range to.Tokens {
+		found := false
+		for _, vFrom := range from.Tokens {
+			if vTo.Id == vFrom.Id {
+				found = true
+				break
+			}
+		}
+		if !found {
+			if err := add("Token", "add", vTo); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, vFrom := range from.LocalInterceptors {
+		found := false
+		for _, vTo := range to.LocalInterceptors {
+			if vTo.Id == vFrom.Id {
+				found = true
+				if !reflect.DeepEqual(vFrom, vTo) {
+					if err := add("LocalInterceptor", "update", vTo); err != nil {
+						return err
+					}
+				}
+				break
+			}
+		}
+		if !found {
+			if err := add("LocalInterceptor", "remove", vFrom); err != nil {
+				return err
+			}
+		}
+	}
+	for _, vTo := range to.LocalInterceptors {
+		found := false
+		for _, vFrom := range from.LocalInterceptors {
+			if vTo.Id == vFrom.Id {
+				found = true
+				break
+			}
+		}
+		if !found {
+			if err := add("LocalInterceptor", "add", vTo); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, vFrom := range from.RemoteInterceptors {
+		found := false
+		for _, vTo := range to.RemoteInterceptors {
+			if vTo.Id == vFrom.Id {
+				found = true
+				if !reflect.DeepEqual(vFrom, vTo) {
+					if err := add("RemoteInterceptor", "update", vTo); err != nil {
+						return err
+					}
+				}
+				break
+			}
+		}
+		if !found {
+			if err := add("RemoteInterceptor", "remove", vFrom); err != nil {
+				return err
+			}
+		}
+	}
+	for _, vTo := range to.RemoteInterceptors {
+		found := false
+		for _, vFrom := range from.RemoteInterceptors {
+			if vTo.Id == vFrom.Id {
+				found = true
+				break
+			}
+		}
+		if !found {
+			if err := add("RemoteInterceptor", "add", vTo); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
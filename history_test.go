@@ -0,0 +1,102 @@
+// history_test
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDiffMasterData(t *testing.T) {
+	tests := []struct {
+		name        string
+		edit        func(to *MasterData)
+		wantChanges []string
+	}{
+		{"no changes", func(to *MasterData) {}, []string{}},
+		{"add data node", func(to *MasterData) {
+			to.DataNodes = append(to.DataNodes, &DataNode{Id: "dn2", Name: "dn2"})
+		}, []string{"add DataNode"}},
+		{"update data node", func(to *MasterData) {
+			to.DataNodes[0].Host = "db.local"
+		}, []string{"update DataNode"}},
+		{"remove app", func(to *MasterData) {
+			to.Apps = nil
+		}, []string{"remove App"}},
+		{"update app", func(to *MasterData) {
+			to.Apps[0].Note = "note"
+		}, []string{"update App"}},
+		{"add query", func(to *MasterData) {
+			to.Apps[0].Queries = append(to.Apps[0].Queries, &Query{Id: "q2", Name: "q2", AppId: "app1"})
+		}, []string{"add Query"}},
+		{"update query", func(to *MasterData) {
+			to.Apps[0].Queries[0].ScriptText = "select 1"
+		}, []string{"update Query"}},
+		{"remove query", func(to *MasterData) {
+			to.Apps[0].Queries = nil
+		}, []string{"remove Query"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			from := testMasterData()
+			to := testMasterData()
+			test.edit(to)
+			changes, err := DiffMasterData(from, to)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(changes) != len(test.wantChanges) {
+				t.Fatalf("got %v changes, want %v", len(changes), test.wantChanges)
+			}
+			for i, change := range changes {
+				if got := change.Op + " " + change.Entity; got != test.wantChanges[i] {
+					t.Errorf("change %v = %v, want %v", i, got, test.wantChanges[i])
+				}
+			}
+			err = from.ApplyChanges(changes)
+			if err != nil {
+				t.Fatal(err)
+			}
+			changes, err = DiffMasterData(from, to)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(changes) != 0 {
+				t.Errorf("%v changes left after applying the diff", len(changes))
+			}
+		})
+	}
+}
+
+func TestMasterDataHistory(t *testing.T) {
+	dir := t.TempDir()
+	history, err := OpenMasterDataHistory(dir, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for version := int64(1); version <= 3; version++ {
+		masterData := &MasterData{Version: version, DataNodes: []*DataNode{{Id: "dn1", Port: int(version)}}}
+		err = history.Record(masterData, "CLI_DN_UPDATE")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := history.Load(1); err == nil {
+		t.Error("version 1 still loads, want it dropped beyond the history size")
+	}
+	loaded, err := history.Load(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Version != 2 || loaded.DataNodes[0].Port != 2 {
+		t.Errorf("loaded version %v with port %v, want version 2 with port 2", loaded.Version, loaded.DataNodes[0].Port)
+	}
+
+	reopened, err := OpenMasterDataHistory(dir, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(reopened.List()), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], "3 ") || !strings.HasSuffix(lines[0], " CLI_DN_UPDATE") {
+		t.Errorf("reopened history lists %q, want versions 3 and 2", lines)
+	}
+}
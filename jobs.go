@@ -8,6 +8,7 @@ import (
 	"log"
 	"os"
 	"strings"
+	"sync"
 
 	"github.com/elgs/cron"
 	"github.com/elgs/gorest2"
@@ -103,10 +104,11 @@ func (this *Job) Action(mode string) func() {
 
 var Sched *cron.Cron
 var jobStatus = make(map[string]int)
+var jobStatusMutex = &sync.Mutex{}
 
 func StartJobs() {
 	Sched = cron.New()
-	for _, app := range masterData.Apps {
+	for _, app := range getMasterIndex().Apps() {
 		for _, job := range app.Jobs {
 			if job.AutoStart == 1 {
 				err := job.Start()
@@ -120,15 +122,20 @@ func StartJobs() {
 	Sched.Start()
 }
 
+// Start schedules the job with its scripts as read now. The scripts are read
+// into a copy, the job in the master data is left as is.
 func (this *Job) Start() error {
+	jobStatusMutex.Lock()
+	defer jobStatusMutex.Unlock()
 	if _, ok := jobStatus[this.Id]; ok {
 		return errors.New("Job already started: " + this.Id)
 	}
-	err := this.Reload()
+	job := *this
+	err := job.Reload()
 	if err != nil {
 		return err
 	}
-	jobRuntimeId, err := Sched.AddFunc(this.Cron, this.Action("sql"))
+	jobRuntimeId, err := Sched.AddFunc(job.Cron, job.Action("sql"))
 	if err != nil {
 		return err
 	}
@@ -143,6 +150,8 @@ func (this *Job) Restart() error {
 	return this.Start()
 }
 func (this *Job) Stop() error {
+	jobStatusMutex.Lock()
+	defer jobStatusMutex.Unlock()
 	if jobRuntimeId, ok := jobStatus[this.Id]; ok {
 		Sched.RemoveFunc(jobRuntimeId)
 		delete(jobStatus, this.Id)
@@ -152,6 +161,8 @@ func (this *Job) Stop() error {
 	return nil
 }
 func (this *Job) Started() bool {
+	jobStatusMutex.Lock()
+	defer jobStatusMutex.Unlock()
 	if _, ok := jobStatus[this.Id]; ok {
 		return true
 	} else {
@@ -159,18 +170,55 @@ func (this *Job) Started() bool {
 	}
 }
 
-func (this *Job) Reload() error {
-	var app *App = nil
-	for iApp, vApp := range masterData.Apps {
-		if this.AppId == vApp.Id {
-			app = masterData.Apps[iApp]
-			break
+// stopAllJobs stops every started job, and returns their ids.
+func stopAllJobs() []string {
+	jobStatusMutex.Lock()
+	defer jobStatusMutex.Unlock()
+	ids := []string{}
+	for id, jobRuntimeId := range jobStatus {
+		Sched.RemoveFunc(jobRuntimeId)
+		delete(jobStatus, id)
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// startJobsById starts the jobs with the given ids, stopped by stopAllJobs.
+func startJobsById(ids []string) {
+	for _, app := range getMasterIndex().Apps() {
+		for _, job := range app.Jobs {
+			for _, id := range ids {
+				if job.Id == id {
+					err := job.Start()
+					if err != nil {
+						log.Println(err)
+					}
+				}
+			}
 		}
 	}
+}
 
-	if app == nil {
-		return errors.New("App not found: " + this.AppId)
+// Reload reads the script and the loop script of the job, each unless
+// uploaded as text.
+func (this *Job) Reload() error {
+	app, err := getMasterIndex().App(this.AppId)
+	if err != nil {
+		return err
+	}
+	if this.ScriptSource != ScriptSourceText {
+		err = this.reloadScript(app)
+		if err != nil {
+			return err
+		}
+	}
+	if this.LoopScriptSource != ScriptSourceText {
+		this.reloadLoopScript(app)
 	}
+	return nil
+}
+
+func (this *Job) reloadScript(app *App) error {
 	if strings.TrimSpace(this.ScriptPath) == "" {
 		jFileFound := false
 		jFileName := ".netdata/" + app.Name + "/" + this.Name
@@ -207,7 +255,11 @@ func (this *Job) Reload() error {
 		}
 		this.ScriptText = string(content)
 	}
+	return nil
+}
 
+// reloadLoopScript reads the optional loop script of the job.
+func (this *Job) reloadLoopScript(app *App) {
 	if strings.TrimSpace(this.LoopScriptPath) == "" {
 		jFileFound := false
 		jFileName := ".netdata/" + app.Name + "/" + this.Name + "_loop"
@@ -243,5 +295,4 @@ func (this *Job) Reload() error {
 			this.LoopScriptText = string(content)
 		}
 	}
-	return nil
 }
@@ -0,0 +1,303 @@
+// journal
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// JournalEntry is one mutation of the master data, along with the version it
+// resulted in.
+type JournalEntry struct {
+	Version int64
+	Time    int64
+	Changes []*MasterDataChange
+}
+
+// Journal is an append-only write-ahead log of master data mutations. Every
+// entry is durable before the mutation is acknowledged. The journal is
+// replayed on top of the snapshot in the master data store on startup, and
+// compacted into the store periodically.
+type Journal interface {
+	Append(entry *JournalEntry) error
+	// Replay applies all entries newer than the version of masterData.
+	Replay(masterData *MasterData) error
+	// Compact saves a snapshot of masterData to the master data store and
+	// empties the journal.
+	Compact(masterData *MasterData) error
+	// Reset empties the journal, without saving a snapshot.
+	Reset() error
+}
+
+var masterJournal Journal
+
+// OpenMasterJournal opens the journal kept along with the master data store:
+// in the database of the sqlite and mysql stores, in the journal file
+// otherwise.
+func OpenMasterJournal(store MasterDataStore) (Journal, error) {
+	if sqlStore, ok := store.(*SqlMasterDataStore); ok {
+		return NewSqlJournal(sqlStore)
+	}
+	return OpenJournal(service.JournalFile)
+}
+
+// FileJournal keeps the journal in a file, one json entry per line, fsynced
+// on every append.
+type FileJournal struct {
+	File    string
+	mutex   *sync.Mutex
+	f       *os.File
+	entries int
+}
+
+func OpenJournal(file string) (*FileJournal, error) {
+	f, err := os.OpenFile(file, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &FileJournal{
+		File:  file,
+		mutex: &sync.Mutex{},
+		f:     f,
+	}, nil
+}
+
+func (this *FileJournal) Append(entry *JournalEntry) error {
+	entryBytes, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	_, err = this.f.Write(append(entryBytes, '\n'))
+	if err != nil {
+		return err
+	}
+	err = this.f.Sync()
+	if err != nil {
+		return err
+	}
+	this.entries++
+	return nil
+}
+
+// Replay applies all entries newer than the version of masterData. A torn
+// entry at the end of the journal, left by a crash in the middle of an
+// append, is discarded.
+func (this *FileJournal) Replay(masterData *MasterData) error {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	_, err := this.f.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	reader := bufio.NewReader(this.f)
+	var offset int64 = 0
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			if len(line) > 0 {
+				log.Println("Discarding torn journal entry at offset", offset)
+				err = this.f.Truncate(offset)
+				if err != nil {
+					return err
+				}
+			}
+			break
+		}
+		if err != nil {
+			return err
+		}
+		entry := &JournalEntry{}
+		err = json.Unmarshal(line, entry)
+		if err != nil {
+			return err
+		}
+		offset += int64(len(line))
+		this.entries++
+		if entry.Version <= masterData.Version {
+			continue
+		}
+		err = masterData.ApplyChanges(entry.Changes)
+		if err != nil {
+			return err
+		}
+		masterData.Version = entry.Version
+	}
+	if this.entries > 0 {
+		log.Println("Replayed", this.entries, "journal entries, version:", masterData.Version)
+	}
+	return nil
+}
+
+func (this *FileJournal) Compact(masterData *MasterData) error {
+	masterDataMutex.Lock()
+	defer masterDataMutex.Unlock()
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	if this.entries == 0 {
+		return nil
+	}
+	err := masterStore.Save(masterData)
+	if err != nil {
+		return err
+	}
+	return this.truncate()
+}
+
+func (this *FileJournal) Reset() error {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	return this.truncate()
+}
+
+func (this *FileJournal) truncate() error {
+	err := this.f.Truncate(0)
+	if err != nil {
+		return err
+	}
+	err = this.f.Sync()
+	if err != nil {
+		return err
+	}
+	this.entries = 0
+	return nil
+}
+
+// SqlJournal keeps the journal in a table next to the snapshot of a
+// SqlMasterDataStore, so that both live in the same database. Compaction
+// saves the snapshot and deletes the entries it covers in one transaction.
+type SqlJournal struct {
+	store   *SqlMasterDataStore
+	mutex   *sync.Mutex
+	entries int
+}
+
+func NewSqlJournal(store *SqlMasterDataStore) (*SqlJournal, error) {
+	_, err := store.db.Exec(`CREATE TABLE IF NOT EXISTS nd_master_journal (
+		VERSION BIGINT NOT NULL PRIMARY KEY,
+		TIME BIGINT NOT NULL,
+		CHANGES LONGTEXT NOT NULL
+	)`)
+	if err != nil {
+		return nil, err
+	}
+	return &SqlJournal{
+		store: store,
+		mutex: &sync.Mutex{},
+	}, nil
+}
+
+func (this *SqlJournal) Append(entry *JournalEntry) error {
+	changesBytes, err := json.Marshal(entry.Changes)
+	if err != nil {
+		return err
+	}
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	_, err = this.store.db.Exec("INSERT INTO nd_master_journal (VERSION, TIME, CHANGES) VALUES (?, ?, ?)",
+		entry.Version, entry.Time, string(changesBytes))
+	if err != nil {
+		return err
+	}
+	this.entries++
+	return nil
+}
+
+func (this *SqlJournal) Replay(masterData *MasterData) error {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	rows, err := this.store.db.Query("SELECT VERSION, TIME, CHANGES FROM nd_master_journal ORDER BY VERSION")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	this.entries = 0
+	for rows.Next() {
+		entry := &JournalEntry{}
+		var changes string
+		err = rows.Scan(&entry.Version, &entry.Time, &changes)
+		if err != nil {
+			return err
+		}
+		this.entries++
+		if entry.Version <= masterData.Version {
+			continue
+		}
+		err = json.Unmarshal([]byte(changes), &entry.Changes)
+		if err != nil {
+			return err
+		}
+		err = masterData.ApplyChanges(entry.Changes)
+		if err != nil {
+			return err
+		}
+		masterData.Version = entry.Version
+	}
+	err = rows.Err()
+	if err != nil {
+		return err
+	}
+	if this.entries > 0 {
+		log.Println("Replayed", this.entries, "journal entries, version:", masterData.Version)
+	}
+	return nil
+}
+
+func (this *SqlJournal) Compact(masterData *MasterData) error {
+	masterDataMutex.Lock()
+	defer masterDataMutex.Unlock()
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	if this.entries == 0 {
+		return nil
+	}
+	tx, err := this.store.db.Begin()
+	if err != nil {
+		return err
+	}
+	err = this.store.saveTx(tx, masterData)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM nd_master_journal WHERE VERSION <= ?", masterData.Version)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+	this.entries = 0
+	return nil
+}
+
+func (this *SqlJournal) Reset() error {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	_, err := this.store.db.Exec("DELETE FROM nd_master_journal")
+	if err != nil {
+		return err
+	}
+	this.entries = 0
+	return nil
+}
+
+func compactJournalEvery(journal Journal, interval time.Duration, masterData *MasterData) {
+	if interval <= 0 {
+		return
+	}
+	for range time.Tick(interval) {
+		err := journal.Compact(masterData)
+		if err != nil {
+			log.Println("Failed to compact journal:", err)
+		}
+	}
+}
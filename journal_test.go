@@ -0,0 +1,146 @@
+// journal_test
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func appendDataNodeChange(t *testing.T, journal Journal, version int64, op string, dataNode *DataNode) {
+	change, err := NewMasterDataChange("DataNode", op, dataNode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = journal.Append(&JournalEntry{Version: version, Changes: []*MasterDataChange{change}})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func replayJournal(t *testing.T, file string, masterData *MasterData) {
+	journal, err := OpenJournal(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = journal.Replay(masterData)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestJournalReplay(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "journal")
+	journal, err := OpenJournal(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	appendDataNodeChange(t, journal, 1, "add", &DataNode{Id: "1", Name: "dn1"})
+	appendDataNodeChange(t, journal, 2, "add", &DataNode{Id: "2", Name: "dn2"})
+	appendDataNodeChange(t, journal, 3, "update", &DataNode{Id: "2", Name: "dn2", Host: "db2"})
+
+	masterData := &MasterData{}
+	replayJournal(t, file, masterData)
+	if masterData.Version != 3 || len(masterData.DataNodes) != 2 || masterData.DataNodes[1].Host != "db2" {
+		t.Fatalf("replayed to version %v, data nodes %+v", masterData.Version, masterData.DataNodes)
+	}
+
+	// Entries the snapshot already holds are skipped.
+	snapshot := &MasterData{Version: 2, DataNodes: []*DataNode{{Id: "1", Name: "dn1"}, {Id: "2", Name: "dn2"}}}
+	replayJournal(t, file, snapshot)
+	if snapshot.Version != 3 || len(snapshot.DataNodes) != 2 || snapshot.DataNodes[1].Host != "db2" {
+		t.Errorf("replayed snapshot to version %v, data nodes %+v", snapshot.Version, snapshot.DataNodes)
+	}
+}
+
+func TestJournalTornTail(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "journal")
+	journal, err := OpenJournal(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	appendDataNodeChange(t, journal, 1, "add", &DataNode{Id: "1", Name: "dn1"})
+	info, err := os.Stat(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"Version":2,"Changes":[{"Entity":"DataN`)
+	f.Close()
+
+	masterData := &MasterData{}
+	journal, err = OpenJournal(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = journal.Replay(masterData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if masterData.Version != 1 || len(masterData.DataNodes) != 1 {
+		t.Fatalf("replayed to version %v with %v data nodes, want version 1 with 1", masterData.Version, len(masterData.DataNodes))
+	}
+	truncated, err := os.Stat(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if truncated.Size() != info.Size() {
+		t.Errorf("journal is %v bytes after replay, want the torn entry cut to %v", truncated.Size(), info.Size())
+	}
+
+	// Appends after the cut leave a journal that replays in full.
+	appendDataNodeChange(t, journal, 2, "add", &DataNode{Id: "2", Name: "dn2"})
+	masterData = &MasterData{}
+	replayJournal(t, file, masterData)
+	if masterData.Version != 2 || len(masterData.DataNodes) != 2 {
+		t.Errorf("replayed to version %v with %v data nodes, want version 2 with 2", masterData.Version, len(masterData.DataNodes))
+	}
+}
+
+func TestSqlJournal(t *testing.T) {
+	store, err := NewSqlMasterDataStore("sqlite3", filepath.Join(t.TempDir(), "netdata_master.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.db.Close()
+	journal, err := NewSqlJournal(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	appendDataNodeChange(t, journal, 1, "add", &DataNode{Id: "1", Name: "dn1"})
+	appendDataNodeChange(t, journal, 2, "add", &DataNode{Id: "2", Name: "dn2"})
+
+	masterData := &MasterData{}
+	err = journal.Replay(masterData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if masterData.Version != 2 || len(masterData.DataNodes) != 2 {
+		t.Fatalf("replayed to version %v with %v data nodes, want version 2 with 2", masterData.Version, len(masterData.DataNodes))
+	}
+
+	// Compaction moves the entries into the snapshot of the store.
+	err = journal.Compact(masterData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	appendDataNodeChange(t, journal, 3, "remove", &DataNode{Id: "1"})
+	restored := &MasterData{}
+	err = store.Load(restored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored.Version != 2 || len(restored.DataNodes) != 2 {
+		t.Fatalf("snapshot at version %v with %v data nodes, want version 2 with 2", restored.Version, len(restored.DataNodes))
+	}
+	err = journal.Replay(restored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored.Version != 3 || len(restored.DataNodes) != 1 || restored.DataNodes[0].Id != "2" {
+		t.Errorf("replayed snapshot to version %v, data nodes %+v", restored.Version, restored.DataNodes)
+	}
+}
@@ -0,0 +1,332 @@
+// list
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/urfave/cli"
+	"gopkg.in/yaml.v3"
+)
+
+// The master answers CLI_<KIND>_LIST and CLI_<KIND>_DESCRIBE with json, which
+// the cli renders as json, yaml or a table.
+
+// listColumns are the fields shown in the table of each kind of entity.
+var listColumns = map[string][]string{
+	"DN":    {"Id", "Name", "Host", "Port", "Type", "Status"},
+	"APP":   {"Id", "Name", "DbName", "DataNodeId", "Status"},
+	"QUERY": {"Id", "Name", "AppId", "Mode", "ScriptSource", "ScriptPath", "Status"},
+	"JOB":   {"Id", "Name", "AppId", "Cron", "ScriptSource", "AutoStart", "Running", "Status"},
+	"TOKEN": {"Id", "Name", "AppId", "Mode", "Target", "Status"},
+	"LI":    {"Id", "Name", "AppId", "Type", "Target", "Callback", "Status"},
+	"RI":    {"Id", "Name", "AppId", "Type", "ActionType", "Method", "Url", "Status"},
+}
+
+// JobListing is a job together with whether it is scheduled on the master.
+type JobListing struct {
+	*Job
+	Running bool
+}
+
+func maskDataNode(dataNode *DataNode) *DataNode {
+	masked := *dataNode
+	if masked.Password != "" {
+		masked.Password = "********"
+	}
+	return &masked
+}
+
+// ListEntities returns the entities of kind, only those of the app with appId
+// if set.
+func (this *MasterData) ListEntities(kind string, appId string) ([]interface{}, error) {
+	if _, ok := entityNames[kind]; !ok {
+		return nil, errors.New("Unknown entity: " + kind)
+	}
+	entities := []interface{}{}
+	if kind == "DN" {
+		for _, v := range this.DataNodes {
+			if appId != "" {
+				app := this.findApp(appId)
+				if app == nil || app.DataNodeId != v.Id {
+					continue
+				}
+			}
+			entities = append(entities, maskDataNode(v))
+		}
+		return entities, nil
+	}
+	for _, app := range this.Apps {
+		if appId != "" && app.Id != appId {
+			continue
+		}
+		switch kind {
+		case "APP":
+			summary := *app
+			summary.Queries = nil
+			summary.Jobs = nil
+			summary.Tokens = nil
+			summary.LocalInterceptors = nil
+			summary.RemoteInterceptors = nil
+			entities = append(entities, &summary)
+		case "QUERY":
+			for _, v := range app.Queries {
+				entities = append(entities, v)
+			}
+		case "JOB":
+			for _, v := range app.Jobs {
+				entities = append(entities, &JobListing{v, v.Started()})
+			}
+		case "TOKEN":
+			for _, v := range app.Tokens {
+				entities = append(entities, v)
+			}
+		case "LI":
+			for _, v := range app.LocalInterceptors {
+				entities = append(entities, v)
+			}
+		case "RI":
+			for _, v := range app.RemoteInterceptors {
+				entities = append(entities, v)
+			}
+		}
+	}
+	return entities, nil
+}
+
+// DescribeEntity returns the entity of kind with id, in the app with appId
+// for the entities of apps.
+func (this *MasterData) DescribeEntity(kind string, id string, appId string) (interface{}, error) {
+	switch kind {
+	case "DN":
+		for _, v := range this.DataNodes {
+			if v.Id == id {
+				return maskDataNode(v), nil
+			}
+		}
+		return nil, errors.New("Data node not found: " + id)
+	case "APP":
+		app := this.findApp(id)
+		if app == nil {
+			return nil, errors.New("App not found: " + id)
+		}
+		return app, nil
+	}
+	if appId == "" {
+		return nil, errors.New("App required to describe a " + entityNames[kind] + ".")
+	}
+	entities, err := this.ListEntities(kind, appId)
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range entities {
+		ref := &entityRef{}
+		err := deepCopy(v, ref)
+		if err != nil {
+			return nil, err
+		}
+		if ref.Id == id {
+			return v, nil
+		}
+	}
+	return nil, errors.New("No " + entityNames[kind] + " with id: " + id)
+}
+
+// processListCommand answers CLI_<KIND>_LIST and CLI_<KIND>_DESCRIBE
+// commands with json.
+func processListCommand(cliCommand *Command) (string, error) {
+	parts := strings.SplitN(strings.TrimPrefix(cliCommand.Type, "CLI_"), "_", 2)
+	if len(parts) != 2 {
+		return "", errors.New("Unknown command: " + cliCommand.Type)
+	}
+	kind, op := parts[0], parts[1]
+	ref := &entityRef{}
+	err := json.Unmarshal([]byte(cliCommand.Data), ref)
+	if err != nil {
+		return "", err
+	}
+	var result interface{}
+	if op == "DESCRIBE" {
+		result, err = masterData.DescribeEntity(kind, ref.Id, ref.AppId)
+	} else {
+		result, err = masterData.ListEntities(kind, ref.AppId)
+	}
+	if err != nil {
+		return "", err
+	}
+	resultBytes, err := json.Marshal(result)
+	if err != nil {
+		return "", err
+	}
+	return string(resultBytes), nil
+}
+
+// renderOutput formats the json answer of the master in format, one of json,
+// yaml and table.
+func renderOutput(kind string, format string, response []byte) (string, error) {
+	var value interface{}
+	err := json.Unmarshal(response, &value)
+	if err != nil {
+		// Not json, an error message of the master.
+		return "", errors.New(strings.TrimSpace(string(response)))
+	}
+	switch format {
+	case "json":
+		output, err := json.MarshalIndent(value, "", "  ")
+		if err != nil {
+			return "", err
+		}
+		return string(output), nil
+	case "yaml":
+		output, err := yaml.Marshal(value)
+		if err != nil {
+			return "", err
+		}
+		return string(output), nil
+	case "", "table":
+		var buffer bytes.Buffer
+		w := tabwriter.NewWriter(&buffer, 0, 4, 2, ' ', 0)
+		switch v := value.(type) {
+		case []interface{}:
+			columns := listColumns[kind]
+			fmt.Fprintln(w, strings.ToUpper(strings.Join(columns, "\t")))
+			for _, row := range v {
+				fields, _ := row.(map[string]interface{})
+				values := []string{}
+				for _, column := range columns {
+					values = append(values, formatField(fields[column]))
+				}
+				fmt.Fprintln(w, strings.Join(values, "\t"))
+			}
+		case map[string]interface{}:
+			keys := []string{}
+			for key := range v {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			for _, key := range keys {
+				fmt.Fprintf(w, "%v:\t%v\n", key, formatField(v[key]))
+			}
+		}
+		w.Flush()
+		return strings.TrimSpace(buffer.String()), nil
+	}
+	return "", errors.New("Unknown output format: " + format)
+}
+
+// formatField renders a json value in a table cell, nested lists by count.
+func formatField(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case []interface{}:
+		return fmt.Sprint(len(v), " items")
+	case map[string]interface{}:
+		return fmt.Sprint(len(v), " fields")
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return fmt.Sprint(value)
+}
+
+func listFlags() []cli.Flag {
+	return []cli.Flag{
+		cli.StringFlag{
+			Name:  "node, N",
+			Value: "127.0.0.1:2015",
+			Usage: "node url, format: host:port. 127.0.0.1:2015 if empty",
+		},
+		cli.StringFlag{
+			Name:  "app, a",
+			Usage: "app name or id",
+		},
+		cli.StringFlag{
+			Name:  "output, o",
+			Value: "table",
+			Usage: "output format: json, yaml or table",
+		},
+		cli.StringFlag{
+			Name:        "secret, z",
+			Usage:       "secret password for server client communication.",
+			Destination: &service.Secret,
+		},
+	}
+}
+
+func sendListCommand(c *cli.Context, kind string, command *Command) error {
+	response, err := sendCliCommand(cliNode(c), command, true)
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+	output, err := renderOutput(kind, c.String("output"), response)
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+	if output != "" {
+		fmt.Println(output)
+	}
+	return nil
+}
+
+// listCommand builds the cli command listing the entities of kind.
+func listCommand(kind string) cli.Command {
+	return cli.Command{
+		Name:  "list",
+		Usage: "list " + entityNames[kind] + "s, of an app if set",
+		Flags: listFlags(),
+		Action: func(c *cli.Context) error {
+			if err := service.LoadSecrets(c); err != nil {
+				fmt.Println(err)
+				return err
+			}
+			appId := c.String("app")
+			if kind != "DN" && kind != "APP" {
+				appId = cliApp(c)
+			}
+			refBytes, err := json.Marshal(&entityRef{AppId: appId})
+			if err != nil {
+				fmt.Println(err)
+				return err
+			}
+			return sendListCommand(c, kind, &Command{
+				Type: "CLI_" + kind + "_LIST",
+				Data: string(refBytes),
+			})
+		},
+	}
+}
+
+// describeCommand builds the cli command showing one entity of kind.
+func describeCommand(kind string) cli.Command {
+	return cli.Command{
+		Name:  "describe",
+		Usage: "describe a " + entityNames[kind],
+		Flags: append(listFlags(), cli.StringFlag{
+			Name:  "id, i",
+			Usage: "name or id of the " + entityNames[kind],
+		}),
+		Action: func(c *cli.Context) error {
+			if err := service.LoadSecrets(c); err != nil {
+				fmt.Println(err)
+				return err
+			}
+			refBytes, err := json.Marshal(&entityRef{Id: c.String("id"), AppId: cliApp(c)})
+			if err != nil {
+				fmt.Println(err)
+				return err
+			}
+			return sendListCommand(c, kind, &Command{
+				Type: "CLI_" + kind + "_DESCRIBE",
+				Data: string(refBytes),
+			})
+		},
+	}
+}
@@ -0,0 +1,74 @@
+// list_test
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRenderOutput(t *testing.T) {
+	list := `[{"Id":"1","Name":"dn1","Host":"localhost","Port":3306,"Type":"mysql","Status":""}]`
+	entity := `{"Name":"app1","Id":"a1","Queries":[{"Id":"q1"},{"Id":"q2"}]}`
+	tests := []struct {
+		name     string
+		kind     string
+		format   string
+		response string
+		want     []string
+		wantErr  string
+	}{
+		{"table of a list", "DN", "table", list, []string{
+			"ID  NAME  HOST       PORT  TYPE   STATUS",
+			"1   dn1   localhost  3306  mysql",
+		}, ""},
+		{"table by default", "DN", "", list, []string{"ID  NAME"}, ""},
+		{"table of an entity", "APP", "table", entity, []string{
+			"Id:       a1",
+			"Name:     app1",
+			"Queries:  2 items",
+		}, ""},
+		{"json", "APP", "json", entity, []string{"{\n  \"Id\": \"a1\",", "\"Queries\": ["}, ""},
+		{"yaml", "APP", "yaml", entity, []string{"Id: a1", "Name: app1", "Queries:\n    - Id: q1"}, ""},
+		{"error of the master", "APP", "table", "App not found: x\n", nil, "App not found: x"},
+		{"unknown format", "APP", "xml", entity, nil, "Unknown output format: xml"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			output, err := renderOutput(test.kind, test.format, []byte(test.response))
+			if test.wantErr != "" {
+				if err == nil || err.Error() != test.wantErr {
+					t.Fatalf("err = %v, want %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, want := range test.want {
+				if !strings.Contains(output, want) {
+					t.Errorf("output =\n%v\nwant it to contain\n%v", output, want)
+				}
+			}
+		})
+	}
+}
+
+func TestFormatField(t *testing.T) {
+	tests := []struct {
+		value interface{}
+		want  string
+	}{
+		{nil, ""},
+		{"dn1", "dn1"},
+		{float64(3306), "3306"},
+		{1.5, "1.5"},
+		{true, "true"},
+		{[]interface{}{1, 2}, "2 items"},
+		{map[string]interface{}{"a": 1}, "1 fields"},
+	}
+	for _, test := range tests {
+		if got := formatField(test.value); got != test.want {
+			t.Errorf("formatField(%v) = %q, want %q", test.value, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,288 @@
+// master_change
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"sync"
+)
+
+// MasterDataChange describes one entity added to, updated in or removed from
+// the master data. Data holds the json of the entity after the change.
+type MasterDataChange struct {
+	Entity string
+	Op     string
+	Data   string
+}
+
+func NewMasterDataChange(entity string, op string, data interface{}) (*MasterDataChange, error) {
+	dataBytes, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	return &MasterDataChange{
+		Entity: entity,
+		Op:     op,
+		Data:   string(dataBytes),
+	}, nil
+}
+
+var changedVersionsMutex = &sync.Mutex{}
+
+// changedVersions holds the version that last changed each entity since the
+// master started, keyed by changedKey. Entities missing from it count as
+// changed at changedVersionsBase, the version the master started at.
+var changedVersions = map[string]int64{}
+var changedVersionsBase int64
+
+func changedKey(entity string, appId string, id string) string {
+	return entity + ":" + appId + ":" + id
+}
+
+func resetChangedVersions(base int64) {
+	changedVersionsMutex.Lock()
+	changedVersions = map[string]int64{}
+	changedVersionsBase = base
+	changedVersionsMutex.Unlock()
+}
+
+func recordChangedVersions(changes []*MasterDataChange, version int64) {
+	changedVersionsMutex.Lock()
+	defer changedVersionsMutex.Unlock()
+	for _, change := range changes {
+		ref := &entityRef{}
+		if json.Unmarshal([]byte(change.Data), ref) == nil {
+			changedVersions[changedKey(change.Entity, ref.AppId, ref.Id)] = version
+		}
+	}
+}
+
+// changedVersion returns the version that last changed an entity.
+func changedVersion(entity string, appId string, id string) int64 {
+	changedVersionsMutex.Lock()
+	defer changedVersionsMutex.Unlock()
+	if version, ok := changedVersions[changedKey(entity, appId, id)]; ok {
+		return version
+	}
+	return changedVersionsBase
+}
+
+func (this *MasterData) findDataNode(id string) *DataNode {
+	for _, v := range this.DataNodes {
+		if v.Id == id {
+			return v
+		}
+	}
+	return nil
+}
+
+func (this *MasterData) findApp(appId string) *App {
+	for _, vApp := range this.Apps {
+		if vApp.Id == appId {
+			return vApp
+		}
+	}
+	return nil
+}
+
+// ApplyChange applies a change to the master data as is, without any of the
+// side effects of the Add*, Update* and Remove* functions.
+func (this *MasterData) ApplyChange(change *MasterDataChange) error {
+	if change.Op != "add" && change.Op != "update" && change.Op != "remove" {
+		return errors.New("Unknown change operation: " + change.Op)
+	}
+	switch change.Entity {
+	case "DataNode":
+		dataNode := &DataNode{}
+		err := json.Unmarshal([]byte(change.Data), dataNode)
+		if err != nil {
+			return err
+		}
+		for i, v := range this.DataNodes {
+			if v.Id == dataNode.Id {
+				if change.Op == "remove" {
+					this.DataNodes = append(this.DataNodes[:i], this.DataNodes[i+1:]...)
+				} else {
+					this.DataNodes[i] = dataNode
+				}
+				return nil
+			}
+		}
+		if change.Op != "remove" {
+			this.DataNodes = append(this.DataNodes, dataNode)
+		}
+	case "App":
+		app := &App{}
+		err := json.Unmarshal([]byte(change.Data), app)
+		if err != nil {
+			return err
+		}
+		for i, v := range this.Apps {
+			if v.Id == app.Id {
+				if change.Op == "remove" {
+					this.Apps = append(this.Apps[:i], this.Apps[i+1:]...)
+				} else {
+					this.Apps[i] = app
+				}
+				return nil
+			}
+		}
+		if change.Op != "remove" {
+			this.Apps = append(this.Apps, app)
+		}
+	case "Query":
+		query := &Query{}
+		err := json.Unmarshal([]byte(change.Data), query)
+		if err != nil {
+			return err
+		}
+		app := this.findApp(query.AppId)
+		if app == nil {
+			return errors.New("App not found: " + query.AppId)
+		}
+		for i, v := range app.Queries {
+			if v.Id == query.Id {
+				if change.Op == "remove" {
+					app.Queries = append(app.Queries[:i], app.Queries[i+1:]...)
+				} else {
+					app.Queries[i] = query
+				}
+				return nil
+			}
+		}
+		if change.Op != "remove" {
+			app.Queries = append(app.Queries, query)
+		}
+	case "Job":
+		job := &Job{}
+		err := json.Unmarshal([]byte(change.Data), job)
+		if err != nil {
+			return err
+		}
+		app := this.findApp(job.AppId)
+		if app == nil {
+			return errors.New("App not found: " + job.AppId)
+		}
+		for i, v := range app.Jobs {
+			if v.Id == job.Id {
+				if change.Op == "remove" {
+					app.Jobs = append(app.Jobs[:i], app.Jobs[i+1:]...)
+				} else {
+					app.Jobs[i] = job
+				}
+				return nil
+			}
+		}
+		if change.Op != "remove" {
+			app.Jobs = append(app.Jobs, job)
+		}
+	case "Token":
+		token := &Token{}
+		err := json.Unmarshal([]byte(change.Data), token)
+		if err != nil {
+			return err
+		}
+		app := this.findApp(token.AppId)
+		if app == nil {
+			return errors.New("App not found: " + token.AppId)
+		}
+		for i, v := range app.Tokens {
+			if v.Id == token.Id {
+				if change.Op == "remove" {
+					app.Tokens = append(app.Tokens[:i], app.Tokens[i+1:]...)
+				} else {
+					app.Tokens[i] = token
+				}
+				return nil
+			}
+		}
+		if change.Op != "remove" {
+			app.Tokens = append(app.Tokens, token)
+		}
+	case "LocalInterceptor":
+		li := &LocalInterceptor{}
+		err := json.Unmarshal([]byte(change.Data), li)
+		if err != nil {
+			return err
+		}
+		app := this.findApp(li.AppId)
+		if app == nil {
+			return errors.New("App not found: " + li.AppId)
+		}
+		for i, v := range app.LocalInterceptors {
+			if v.Id == li.Id {
+				if change.Op == "remove" {
+					app.LocalInterceptors = append(app.LocalInterceptors[:i], app.LocalInterceptors[i+1:]...)
+				} else {
+					app.LocalInterceptors[i] = li
+				}
+				return nil
+			}
+		}
+		if change.Op != "remove" {
+			app.LocalInterceptors = append(app.LocalInterceptors, li)
+		}
+	case "RemoteInterceptor":
+		ri := &RemoteInterceptor{}
+		err := json.Unmarshal([]byte(change.Data), ri)
+		if err != nil {
+			return err
+		}
+		app := this.findApp(ri.AppId)
+		if app == nil {
+			return errors.New("App not found: " + ri.AppId)
+		}
+		for i, v := range app.RemoteInterceptors {
+			if v.Id == ri.Id {
+				if change.Op == "remove" {
+					app.RemoteInterceptors = append(app.RemoteInterceptors[:i], app.RemoteInterceptors[i+1:]...)
+				} else {
+					app.RemoteInterceptors[i] = ri
+				}
+				return nil
+			}
+		}
+		if change.Op != "remove" {
+			app.RemoteInterceptors = append(app.RemoteInterceptors, ri)
+		}
+	default:
+		return errors.New("Unknown change entity: " + change.Entity)
+	}
+	return nil
+}
+
+func (this *MasterData) ApplyChanges(changes []*MasterDataChange) error {
+	for _, change := range changes {
+		err := this.ApplyChange(change)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// forgetDbos drops the cached data operators of the apps whose app or data
+// node has changed, so that they are recreated with the new settings.
+func forgetDbos(changes []*MasterDataChange) {
+	for _, change := range changes {
+		if change.Op == "add" || (change.Entity != "App" && change.Entity != "DataNode") {
+			continue
+		}
+		ref := &entityRef{}
+		err := json.Unmarshal([]byte(change.Data), ref)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		if change.Entity == "App" {
+			forgetDbo(ref.Id)
+			continue
+		}
+		for _, app := range getMasterIndex().Apps() {
+			if app.DataNodeId == ref.Id {
+				forgetDbo(app.Id)
+			}
+		}
+	}
+}
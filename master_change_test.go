@@ -0,0 +1,76 @@
+// master_change_test
+package main
+
+import (
+	"testing"
+)
+
+func testMasterData() *MasterData {
+	return &MasterData{
+		DataNodes: []*DataNode{{Id: "dn1", Name: "dn1"}},
+		Apps: []*App{{
+			Id:         "app1",
+			Name:       "app1",
+			DataNodeId: "dn1",
+			Queries:    []*Query{{Id: "q1", Name: "q1", AppId: "app1"}},
+		}},
+	}
+}
+
+func TestApplyChange(t *testing.T) {
+	tests := []struct {
+		name    string
+		entity  string
+		op      string
+		data    interface{}
+		wantErr bool
+		check   func(masterData *MasterData) bool
+	}{
+		{"add data node", "DataNode", "add", &DataNode{Id: "dn2", Name: "dn2"}, false, func(m *MasterData) bool {
+			return len(m.DataNodes) == 2 && m.DataNodes[1].Id == "dn2"
+		}},
+		{"update data node", "DataNode", "update", &DataNode{Id: "dn1", Name: "renamed"}, false, func(m *MasterData) bool {
+			return len(m.DataNodes) == 1 && m.DataNodes[0].Name == "renamed"
+		}},
+		{"remove data node", "DataNode", "remove", &DataNode{Id: "dn1"}, false, func(m *MasterData) bool {
+			return len(m.DataNodes) == 0
+		}},
+		{"remove missing data node", "DataNode", "remove", &DataNode{Id: "dn9"}, false, func(m *MasterData) bool {
+			return len(m.DataNodes) == 1
+		}},
+		{"add query", "Query", "add", &Query{Id: "q2", Name: "q2", AppId: "app1"}, false, func(m *MasterData) bool {
+			return len(m.Apps[0].Queries) == 2
+		}},
+		{"update query", "Query", "update", &Query{Id: "q1", Name: "q1", Mode: "exec", AppId: "app1"}, false, func(m *MasterData) bool {
+			return len(m.Apps[0].Queries) == 1 && m.Apps[0].Queries[0].Mode == "exec"
+		}},
+		{"remove query", "Query", "remove", &Query{Id: "q1", AppId: "app1"}, false, func(m *MasterData) bool {
+			return len(m.Apps[0].Queries) == 0
+		}},
+		{"add token", "Token", "add", &Token{Id: "app1t1", AppId: "app1"}, false, func(m *MasterData) bool {
+			return len(m.Apps[0].Tokens) == 1
+		}},
+		{"remove app", "App", "remove", &App{Id: "app1"}, false, func(m *MasterData) bool {
+			return len(m.Apps) == 0
+		}},
+		{"query of missing app", "Query", "add", &Query{Id: "q2", AppId: "app9"}, true, nil},
+		{"unknown entity", "Widget", "add", &DataNode{Id: "w1"}, true, nil},
+		{"unknown operation", "DataNode", "upsert", &DataNode{Id: "dn2"}, true, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			masterData := testMasterData()
+			change, err := NewMasterDataChange(test.entity, test.op, test.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = masterData.ApplyChange(change)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("err = %v, want error %v", err, test.wantErr)
+			}
+			if test.check != nil && !test.check(masterData) {
+				t.Errorf("unexpected master data after %v %v", test.op, test.entity)
+			}
+		})
+	}
+}
@@ -8,6 +8,9 @@ import (
 	"io/ioutil"
 	"os"
 	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
 )
 
 type Command struct {
@@ -48,28 +51,41 @@ type App struct {
 	RemoteInterceptors []*RemoteInterceptor
 }
 type Query struct {
-	Id         string
-	Name       string
-	ScriptPath string
-	ScriptText string
-	Mode       string
-	AppId      string
-	Note       string
-	Status     string
+	Id           string
+	Name         string
+	ScriptSource string
+	ScriptPath   string
+	ScriptText   string
+	Mode         string
+	AppId        string
+	Note         string
+	Status       string
 }
 type Job struct {
-	Id             string
-	Name           string
-	Cron           string
-	ScriptPath     string
-	ScriptText     string
-	AutoStart      int
-	LoopScriptPath string
-	LoopScriptText string
-	AppId          string
-	Note           string
-	Status         string
+	Id               string
+	Name             string
+	Cron             string
+	ScriptSource     string
+	ScriptPath       string
+	ScriptText       string
+	AutoStart        int
+	LoopScriptSource string
+	LoopScriptPath   string
+	LoopScriptText   string
+	AppId            string
+	Note             string
+	Status           string
 }
+
+// The script of a query or a job is either read by the master from ScriptPath,
+// or from ~/.netdata/<app>/<name>.sql if empty, or uploaded by the cli as
+// ScriptText. An empty ScriptSource is the path source. The loop script of a
+// job has its own LoopScriptSource, LoopScriptPath and LoopScriptText.
+const (
+	ScriptSourcePath = "path"
+	ScriptSourceText = "text"
+)
+
 type Token struct {
 	Id     string
 	Name   string
@@ -115,6 +131,10 @@ type ApiNode struct {
 	SuperRegion string
 	Note        string
 	Status      string
+	// Reported by the slave after applying master data.
+	AppliedVersion int64
+	LastAck        int64
+	AckError       string
 }
 
 func (this *MasterData) AddDataNode(dataNode *DataNode) error {
@@ -123,11 +143,9 @@ func (this *MasterData) AddDataNode(dataNode *DataNode) error {
 			return errors.New("Data node existed: " + dataNode.Name)
 		}
 	}
-	this.DataNodes = append(this.DataNodes, dataNode)
-	this.Version++
-	return masterData.Propagate()
+	return this.CommitChange("DataNode", "add", dataNode)
 }
-func (this *MasterData) RemoveDataNode(id string) error {
+func (this *MasterData) RemoveDataNode(id string, cascade bool) error {
 	index := -1
 	for i, v := range this.DataNodes {
 		if v.Id == id {
@@ -138,53 +156,71 @@ func (this *MasterData) RemoveDataNode(id string) error {
 	if index == -1 {
 		return errors.New("Data node not found: " + id)
 	}
-	copy(this.DataNodes[index:], this.DataNodes[index+1:])
-	this.DataNodes[len(this.DataNodes)-1] = nil
-	this.DataNodes = this.DataNodes[:len(this.DataNodes)-1]
-	//	this.DataNodes = append(this.DataNodes[:index], this.DataNodes[index+1:]...)
-	this.Version++
-	return masterData.Propagate()
-}
-func (this *MasterData) UpdateDataNode(dataNode *DataNode) error {
-	for i, v := range this.DataNodes {
-		if v.Id == dataNode.Id {
-			if v.Name != "__not_set__" {
-				v.Name = dataNode.Name
-			}
-			if v.Host != "__not_set__" {
-				v.Host = dataNode.Host
-			}
-			if v.Port != -1 {
-				v.Port = dataNode.Port
-			}
-			if v.Username != "__not_set__" {
-				v.Username = dataNode.Username
-			}
-			if v.Password != "__not_set__" {
-				v.Password = dataNode.Password
-			}
-			if v.Note != "__not_set__" {
-				v.Note = dataNode.Note
+	dataNode := this.DataNodes[index]
+	dependents := []*App{}
+	for _, vApp := range this.Apps {
+		if vApp.DataNodeId == id {
+			dependents = append(dependents, vApp)
+		}
+	}
+	if len(dependents) > 0 && !cascade {
+		names := []string{}
+		for _, vApp := range dependents {
+			names = append(names, vApp.Name)
+		}
+		return errors.New("Data node is used by apps: " + strings.Join(names, ", ") + ". Remove them first, or cascade.")
+	}
+	// Check every app before dropping the database of any of them.
+	for _, vApp := range dependents {
+		err := vApp.CheckRemove()
+		if err != nil {
+			return err
+		}
+	}
+	changes := []*MasterDataChange{}
+	for _, vApp := range dependents {
+		change, err := this.removeApp(vApp)
+		if err != nil {
+			// Keep the apps already dropped out of the master data.
+			if len(changes) > 0 {
+				commitErr := this.Commit(changes...)
+				if commitErr != nil {
+					return errors.New(err.Error() + ". Failed to remove the apps already dropped: " + commitErr.Error())
+				}
 			}
-			this.DataNodes[i] = v
-			this.Version++
-			return masterData.Propagate()
+			return err
 		}
+		changes = append(changes, change)
+	}
+	change, err := NewMasterDataChange("DataNode", "remove", dataNode)
+	if err != nil {
+		return err
 	}
-	return errors.New("Data node not found: " + dataNode.Name)
+	return this.Commit(append(changes, change)...)
 }
-func (this *MasterData) ListDataNodes(mode string) string {
-	var buffer bytes.Buffer
-	for _, dataNode := range this.DataNodes {
-		if mode == "compact" {
-			buffer.WriteString(dataNode.Name + " ")
-		} else if mode == "full" {
-			buffer.WriteString(fmt.Sprintln(dataNode.Name, dataNode.Host))
-		} else {
-			buffer.WriteString(dataNode.Name + "\n")
+
+func (this *MasterData) UpdateDataNode(id string, patch []byte) error {
+	for _, v := range this.DataNodes {
+		if v.Id == id {
+			dataNode := *v
+			err := mergePatch(&dataNode, patch)
+			if err != nil {
+				return err
+			}
+			dataNode.Id = v.Id
+			for _, vDn := range this.DataNodes {
+				if vDn.Id != id && vDn.Name == dataNode.Name {
+					return errors.New("Data node existed: " + dataNode.Name)
+				}
+			}
+			err = dataNode.Validate()
+			if err != nil {
+				return err
+			}
+			return this.CommitChange("DataNode", "update", &dataNode)
 		}
 	}
-	return buffer.String()
+	return errors.New("Data node not found: " + id)
 }
 
 func (this *MasterData) AddApp(app *App) error {
@@ -207,44 +243,78 @@ func (this *MasterData) AddApp(app *App) error {
 	if err != nil {
 		return err
 	}
-	this.Apps = append(this.Apps, app)
-	this.Version++
-	return masterData.Propagate()
+	return this.CommitChange("App", "add", app)
 }
-func (this *MasterData) RemoveApp(id string) error {
-	index := -1
-	for i, v := range this.Apps {
-		if v.Id == id {
-			index = i
-			err := v.OnAppRemove()
-			if err != nil {
-				return err
-			}
-			break
+func (this *MasterData) RemoveApp(id string, cascade bool) error {
+	app := this.findApp(id)
+	if app == nil {
+		return errors.New("App not found: " + id)
+	}
+	if !cascade {
+		dependents := []string{}
+		for _, v := range app.Queries {
+			dependents = append(dependents, "query "+v.Name)
+		}
+		for _, v := range app.Jobs {
+			dependents = append(dependents, "job "+v.Name)
+		}
+		for _, v := range app.Tokens {
+			dependents = append(dependents, "token "+v.Name)
+		}
+		for _, v := range app.LocalInterceptors {
+			dependents = append(dependents, "local interceptor "+v.Name)
+		}
+		for _, v := range app.RemoteInterceptors {
+			dependents = append(dependents, "remote interceptor "+v.Name)
+		}
+		if len(dependents) > 0 {
+			return errors.New("App is not empty: " + strings.Join(dependents, ", ") + ". Remove them first, or cascade.")
 		}
 	}
-	if index == -1 {
-		return errors.New("App not found: " + id)
+	change, err := this.removeApp(app)
+	if err != nil {
+		return err
 	}
-	copy(this.Apps[index:], this.Apps[index+1:])
-	this.Apps[len(this.Apps)-1] = nil
-	this.Apps = this.Apps[:len(this.Apps)-1]
-	//	this.Apps = append(this.Apps[:index], this.Apps[index+1:]...)
-	this.Version++
-	return masterData.Propagate()
-}
-func (this *MasterData) UpdateApp(app *App) error {
-	iApp := -1
-	var vApp *App
-	for i, v := range this.Apps {
-		if v.Id == app.Id {
-			iApp = i
-			vApp = v
-			break
+	return this.Commit(change)
+}
+
+// removeApp drops the database of the app, stops its jobs and forgets its data
+// operator. The returned change removes it along with everything in it.
+func (this *MasterData) removeApp(app *App) (*MasterDataChange, error) {
+	err := app.OnAppRemove()
+	if err != nil {
+		return nil, err
+	}
+	for _, vJob := range app.Jobs {
+		if vJob.Started() {
+			vJob.Stop()
 		}
 	}
-	if iApp == -1 {
-		return errors.New("App not found: " + app.Name)
+	forgetDbo(app.Id)
+	return NewMasterDataChange("App", "remove", app)
+}
+
+func (this *MasterData) UpdateApp(id string, patch []byte) error {
+	vApp := this.findApp(id)
+	if vApp == nil {
+		return errors.New("App not found: " + id)
+	}
+	app := *vApp
+	err := mergePatch(&app, patch)
+	if err != nil {
+		return err
+	}
+	app.Id = vApp.Id
+	app.DbName = vApp.DbName
+	app.Queries = vApp.Queries
+	app.Jobs = vApp.Jobs
+	app.Tokens = vApp.Tokens
+	app.LocalInterceptors = vApp.LocalInterceptors
+	app.RemoteInterceptors = vApp.RemoteInterceptors
+	for _, v := range this.Apps {
+		if v.Id != id && v.Name == app.Name {
+			return errors.New("App existed: " + app.Name)
+		}
 	}
 
 	found := false
@@ -257,33 +327,16 @@ func (this *MasterData) UpdateApp(app *App) error {
 	if !found {
 		return errors.New("Data node does not exist: " + app.DataNodeId)
 	}
-
-	if app.Name != "__not_set__" {
-		vApp.Name = app.Name
-	}
-	if app.DataNodeId != "__not_set__" {
-		vApp.DataNodeId = app.DataNodeId
-	}
-	if app.Note != "__not_set__" {
-		vApp.Note = app.Note
+	err = app.Validate()
+	if err != nil {
+		return err
 	}
-	vApp.OnAppCreateOrUpdate()
-	this.Apps[iApp] = vApp
-	this.Version++
-	return masterData.Propagate()
-}
-func (this *MasterData) ListApps(mode string) string {
-	var buffer bytes.Buffer
-	for _, app := range this.Apps {
-		if mode == "compact" {
-			buffer.WriteString(app.Name + " ")
-		} else if mode == "full" {
-			buffer.WriteString(fmt.Sprintln(app.Name, app.DataNodeId))
-		} else {
-			buffer.WriteString(app.Name + "\n")
-		}
+
+	err = app.OnAppCreateOrUpdate()
+	if err != nil {
+		return err
 	}
-	return buffer.String()
+	return this.CommitChange("App", "update", &app)
 }
 
 func (this *MasterData) AddQuery(query *Query) error {
@@ -294,81 +347,115 @@ func (this *MasterData) AddQuery(query *Query) error {
 					return errors.New("Query existed: " + query.Name)
 				}
 			}
-			this.Apps[iApp].Queries = append(this.Apps[iApp].Queries, query)
 			err := query.Reload()
 			if err != nil {
 				return err
 			}
-			this.Version++
-			return masterData.Propagate()
+			return this.CommitChange("Query", "add", query)
 		}
 	}
 	return errors.New("App does not exist: " + query.AppId)
 }
-func (this *MasterData) RemoveQuery(id string, appId string) error {
+func (this *MasterData) RemoveQuery(id string, appId string, cascade bool) error {
 	for iApp, _ := range this.Apps {
 		if this.Apps[iApp].Id == appId {
-			for iQuery, vQuery := range this.Apps[iApp].Queries {
+			for _, vQuery := range this.Apps[iApp].Queries {
 				if vQuery.Id == id && vQuery.AppId == appId {
-					copy(this.Apps[iApp].Queries[iQuery:], this.Apps[iApp].Queries[iQuery+1:])
-					this.Apps[iApp].Queries[len(this.Apps[iApp].Queries)-1] = nil
-					this.Apps[iApp].Queries = this.Apps[iApp].Queries[:len(this.Apps[iApp].Queries)-1]
-					//					this.Apps[iApp].Queries = append(this.Apps[iApp].Queries[:iQuery], this.Apps[iApp].Queries[iQuery+1:]...)
-					this.Version++
-					return masterData.Propagate()
-				}
-			}
-		}
-	}
-	return errors.New("Query not found: " + id)
-}
-func (this *MasterData) UpdateQuery(query *Query) error {
-	for iApp, vApp := range this.Apps {
-		if vApp.Id == query.AppId {
-			for iQuery, vQuery := range this.Apps[iApp].Queries {
-				if vQuery.Id == query.Id && vQuery.AppId == query.AppId {
-					if query.Name != "__not_set__" {
-						vQuery.Name = query.Name
+					dependentLis := []*LocalInterceptor{}
+					dependentRis := []*RemoteInterceptor{}
+					dependents := []string{}
+					for _, vLi := range this.Apps[iApp].LocalInterceptors {
+						if vLi.Callback == vQuery.Name || vLi.Target == vQuery.Name {
+							dependentLis = append(dependentLis, vLi)
+							dependents = append(dependents, "local interceptor "+vLi.Name)
+						}
+					}
+					for _, vRi := range this.Apps[iApp].RemoteInterceptors {
+						if vRi.Callback == vQuery.Name || vRi.Target == vQuery.Name {
+							dependentRis = append(dependentRis, vRi)
+							dependents = append(dependents, "remote interceptor "+vRi.Name)
+						}
 					}
-					if query.ScriptPath != "__not_set__" {
-						vQuery.ScriptPath = query.ScriptPath
+					if len(dependents) > 0 && !cascade {
+						return errors.New("Query is used by: " + strings.Join(dependents, ", ") + ". Remove them first, or cascade.")
 					}
-					if query.Mode != "__not_set__" {
-						vQuery.Mode = query.Mode
+					changes := []*MasterDataChange{}
+					for _, vLi := range dependentLis {
+						change, err := this.removeLI(vLi)
+						if err != nil {
+							return err
+						}
+						changes = append(changes, change)
 					}
-					if query.Note != "__not_set__" {
-						vQuery.Note = query.Note
+					for _, vRi := range dependentRis {
+						change, err := this.removeRI(vRi)
+						if err != nil {
+							return err
+						}
+						changes = append(changes, change)
 					}
-					this.Apps[iApp].Queries[iQuery] = vQuery
-					err := vQuery.Reload()
+					change, err := NewMasterDataChange("Query", "remove", vQuery)
 					if err != nil {
 						return err
 					}
-					this.Version++
-					return masterData.Propagate()
+					return this.Commit(append(changes, change)...)
 				}
 			}
 		}
 	}
-	return errors.New("Query not found: " + query.Name)
+	return errors.New("Query not found: " + id)
 }
-func (this *MasterData) ReloadAllQueries(appId string) error {
-	for iApp, vApp := range this.Apps {
-		if vApp.Id == appId {
-			for iQuery, vQuery := range this.Apps[iApp].Queries {
-				if vQuery.AppId == appId {
-					this.Apps[iApp].Queries[iQuery] = vQuery
-					err := vQuery.Reload()
-					if err != nil {
-						return err
-					}
+
+func (this *MasterData) UpdateQuery(id string, appId string, patch []byte) error {
+	app := this.findApp(appId)
+	if app == nil {
+		return errors.New("App does not exist: " + appId)
+	}
+	for _, v := range app.Queries {
+		if v.Id == id {
+			query := *v
+			err := mergePatch(&query, patch)
+			if err != nil {
+				return err
+			}
+			query.Id = v.Id
+			query.AppId = v.AppId
+			for _, vSibling := range app.Queries {
+				if vSibling.Id != id && vSibling.Name == query.Name {
+					return errors.New("Query existed: " + query.Name)
 				}
 			}
-			this.Version++
-			return masterData.Propagate()
+			err = query.Validate()
+			if err != nil {
+				return err
+			}
+			err = query.Reload()
+			if err != nil {
+				return err
+			}
+			return this.CommitChange("Query", "update", &query)
 		}
 	}
-	return nil
+	return errors.New("Query not found: " + id)
+}
+
+func (this *MasterData) ReloadAllQueries(appId string) error {
+	vApp := this.findApp(appId)
+	if vApp == nil {
+		return nil
+	}
+	app := &App{}
+	err := deepCopy(vApp, app)
+	if err != nil {
+		return err
+	}
+	for _, vQuery := range app.Queries {
+		err := vQuery.Reload()
+		if err != nil {
+			return err
+		}
+	}
+	return this.CommitChange("App", "update", app)
 }
 
 func (this *MasterData) AddJob(job *Job) error {
@@ -380,11 +467,19 @@ func (this *MasterData) AddJob(job *Job) error {
 				}
 			}
 			if job.AutoStart == 1 {
-				job.Start()
+				err := job.Reload()
+				if err != nil {
+					return err
+				}
+			}
+			err := this.CommitChange("Job", "add", job)
+			if err != nil {
+				return err
+			}
+			if job.AutoStart == 1 {
+				return job.Start()
 			}
-			this.Apps[iApp].Jobs = append(this.Apps[iApp].Jobs, job)
-			this.Version++
-			return masterData.Propagate()
+			return nil
 		}
 	}
 	return errors.New("App does not exist: " + job.AppId)
@@ -392,58 +487,52 @@ func (this *MasterData) AddJob(job *Job) error {
 func (this *MasterData) RemoveJob(id string, appId string) error {
 	for iApp, _ := range this.Apps {
 		if this.Apps[iApp].Id == appId {
-			for iJob, vJob := range this.Apps[iApp].Jobs {
+			for _, vJob := range this.Apps[iApp].Jobs {
 				if vJob.Id == id && vJob.AppId == appId {
 					if vJob.Started() {
 						vJob.Stop()
 					}
-					copy(this.Apps[iApp].Jobs[iJob:], this.Apps[iApp].Jobs[iJob+1:])
-					this.Apps[iApp].Jobs[len(this.Apps[iApp].Jobs)-1] = nil
-					this.Apps[iApp].Jobs = this.Apps[iApp].Jobs[:len(this.Apps[iApp].Jobs)-1]
-					//					this.Apps[iApp].Jobs = append(this.Apps[iApp].Jobs[:iJob], this.Apps[iApp].Jobs[iJob+1:]...)
-					this.Version++
-					return masterData.Propagate()
+					return this.CommitChange("Job", "remove", vJob)
 				}
 			}
 		}
 	}
 	return errors.New("Job not found: " + id)
 }
-func (this *MasterData) UpdateJob(job *Job) error {
-	for iApp, vApp := range this.Apps {
-		if vApp.Id == job.AppId {
-			for iJob, vJob := range this.Apps[iApp].Jobs {
-				if vJob.Id == job.Id && vJob.AppId == job.AppId {
-					if job.Name != "__not_set__" {
-						vJob.Name = job.Name
-					}
-					if job.ScriptPath != "__not_set__" {
-						vJob.ScriptPath = job.ScriptPath
-					}
-					if job.LoopScriptPath != "__not_set__" {
-						vJob.LoopScriptPath = job.LoopScriptPath
-					}
-					if job.Cron != "__not_set__" {
-						vJob.Cron = job.Cron
-					}
-					if job.AutoStart != -1 {
-						vJob.AutoStart = job.AutoStart
-					}
-					if job.Note != "__not_set__" {
-						vJob.Note = job.Note
-					}
-
-					if vJob.Started() {
-						job.Restart()
-					}
-					this.Apps[iApp].Jobs[iJob] = vJob
-					this.Version++
-					return masterData.Propagate()
+func (this *MasterData) UpdateJob(id string, appId string, patch []byte) error {
+	app := this.findApp(appId)
+	if app == nil {
+		return errors.New("App does not exist: " + appId)
+	}
+	for _, v := range app.Jobs {
+		if v.Id == id {
+			job := *v
+			err := mergePatch(&job, patch)
+			if err != nil {
+				return err
+			}
+			job.Id = v.Id
+			job.AppId = v.AppId
+			for _, vSibling := range app.Jobs {
+				if vSibling.Id != id && vSibling.Name == job.Name {
+					return errors.New("Job existed: " + job.Name)
 				}
 			}
+			err = job.Validate()
+			if err != nil {
+				return err
+			}
+			err = this.CommitChange("Job", "update", &job)
+			if err != nil {
+				return err
+			}
+			if job.Started() {
+				return job.Restart()
+			}
+			return nil
 		}
 	}
-	return errors.New("Job not found: " + job.Name)
+	return errors.New("Job not found: " + id)
 }
 
 func (this *MasterData) StartJob(job *Job) error {
@@ -470,16 +559,14 @@ func (this *MasterData) StopJob(job *Job) error {
 }
 
 func (this *MasterData) AddToken(token *Token) error {
-	for iApp, vApp := range this.Apps {
+	for _, vApp := range this.Apps {
 		if vApp.Id == token.AppId {
 			for _, vToken := range vApp.Tokens {
 				if vToken.Name == token.Name && vToken.AppId == token.AppId {
 					return errors.New("Token existed: " + token.Name)
 				}
 			}
-			this.Apps[iApp].Tokens = append(this.Apps[iApp].Tokens, token)
-			this.Version++
-			return masterData.Propagate()
+			return this.CommitChange("Token", "add", token)
 		}
 	}
 	return errors.New("App does not exist: " + token.AppId)
@@ -487,182 +574,171 @@ func (this *MasterData) AddToken(token *Token) error {
 func (this *MasterData) RemoveToken(id string, appId string) error {
 	for iApp, _ := range this.Apps {
 		if this.Apps[iApp].Id == appId {
-			for iToken, vToken := range this.Apps[iApp].Tokens {
+			for _, vToken := range this.Apps[iApp].Tokens {
 				if vToken.Id == id && vToken.AppId == appId {
-					copy(this.Apps[iApp].Tokens[iToken:], this.Apps[iApp].Tokens[iToken+1:])
-					this.Apps[iApp].Tokens[len(this.Apps[iApp].Tokens)-1] = nil
-					this.Apps[iApp].Tokens = this.Apps[iApp].Tokens[:len(this.Apps[iApp].Tokens)-1]
-					//					this.Apps[iApp].Tokens = append(this.Apps[iApp].Tokens[:iToken], this.Apps[iApp].Tokens[iToken+1:]...)
-					this.Version++
-					return masterData.Propagate()
+					return this.CommitChange("Token", "remove", vToken)
 				}
 			}
 		}
 	}
 	return errors.New("Token not found: " + id)
 }
-func (this *MasterData) UpdateToken(token *Token) error {
-	for iApp, vApp := range this.Apps {
-		if vApp.Id == token.AppId {
-			for iToken, vToken := range this.Apps[iApp].Tokens {
-				if vToken.Id == token.Id && vToken.AppId == token.AppId {
-					if token.Name != "__not_set__" {
-						vToken.Name = token.Name
-					}
-					if token.Mode != "__not_set__" {
-						vToken.Mode = token.Mode
-					}
-					if token.Target != "__not_set__" {
-						vToken.Target = token.Target
-					}
-					if token.Note != "__not_set__" {
-						vToken.Note = token.Note
-					}
-
-					this.Apps[iApp].Tokens[iToken] = vToken
-					this.Version++
-					return masterData.Propagate()
+func (this *MasterData) UpdateToken(id string, appId string, patch []byte) error {
+	app := this.findApp(appId)
+	if app == nil {
+		return errors.New("App does not exist: " + appId)
+	}
+	for _, v := range app.Tokens {
+		if v.Id == id {
+			token := *v
+			err := mergePatch(&token, patch)
+			if err != nil {
+				return err
+			}
+			token.Id = v.Id
+			token.AppId = v.AppId
+			for _, vSibling := range app.Tokens {
+				if vSibling.Id != id && vSibling.Name == token.Name {
+					return errors.New("Token existed: " + token.Name)
 				}
 			}
+			err = token.Validate()
+			if err != nil {
+				return err
+			}
+			return this.CommitChange("Token", "update", &token)
 		}
 	}
-	return errors.New("Token not found: " + token.Name)
+	return errors.New("Token not found: " + id)
 }
 
 func (this *MasterData) AddLI(li *LocalInterceptor) error {
-	for iApp, vApp := range this.Apps {
+	for _, vApp := range this.Apps {
 		if vApp.Id == li.AppId {
 			for _, vLi := range vApp.LocalInterceptors {
 				if vLi.Name == li.Name && vLi.AppId == li.AppId {
 					return errors.New("Local interceptor existed: " + li.Name)
 				}
 			}
-			this.Apps[iApp].LocalInterceptors = append(this.Apps[iApp].LocalInterceptors, li)
-			this.Version++
-			return masterData.Propagate()
+			return this.CommitChange("LocalInterceptor", "add", li)
 		}
 	}
 	return errors.New("App does not exist: " + li.AppId)
 }
 func (this *MasterData) RemoveLI(id string, appId string) error {
-	for iApp, _ := range this.Apps {
-		if this.Apps[iApp].Id == appId {
-			for iLi, vLi := range this.Apps[iApp].LocalInterceptors {
-				if vLi.Id == id && vLi.AppId == appId {
-					copy(this.Apps[iApp].LocalInterceptors[iLi:], this.Apps[iApp].LocalInterceptors[iLi+1:])
-					this.Apps[iApp].LocalInterceptors[len(this.Apps[iApp].LocalInterceptors)-1] = nil
-					this.Apps[iApp].LocalInterceptors = this.Apps[iApp].LocalInterceptors[:len(this.Apps[iApp].LocalInterceptors)-1]
-					//					this.Apps[iApp].LocalInterceptors = append(this.Apps[iApp].LocalInterceptors[:iLi], this.Apps[iApp].LocalInterceptors[iLi+1:]...)
-					this.Version++
-					return masterData.Propagate()
+	app := this.findApp(appId)
+	if app != nil {
+		for _, vLi := range app.LocalInterceptors {
+			if vLi.Id == id {
+				change, err := this.removeLI(vLi)
+				if err != nil {
+					return err
 				}
+				return this.Commit(change)
 			}
 		}
 	}
 	return errors.New("Local interceptor not found: " + id)
 }
-func (this *MasterData) UpdateLI(li *LocalInterceptor) error {
-	for iApp, vApp := range this.Apps {
-		if vApp.Id == li.AppId {
-			for iLi, vLi := range this.Apps[iApp].LocalInterceptors {
-				if vLi.Id == li.Id && vLi.AppId == li.AppId {
-					if li.Name != "__not_set__" {
-						vLi.Name = li.Name
-					}
-					if li.Target != "__not_set__" {
-						vLi.Target = li.Target
-					}
-					if li.Callback != "__not_set__" {
-						vLi.Callback = li.Callback
-					}
-					if li.Type != "__not_set__" {
-						vLi.Type = li.Type
-					}
-					if li.Note != "__not_set__" {
-						vLi.Note = li.Note
-					}
-					this.Apps[iApp].LocalInterceptors[iLi] = vLi
-					this.Version++
-					return masterData.Propagate()
+func (this *MasterData) removeLI(li *LocalInterceptor) (*MasterDataChange, error) {
+	return NewMasterDataChange("LocalInterceptor", "remove", li)
+}
+
+func (this *MasterData) UpdateLI(id string, appId string, patch []byte) error {
+	app := this.findApp(appId)
+	if app == nil {
+		return errors.New("App does not exist: " + appId)
+	}
+	for _, v := range app.LocalInterceptors {
+		if v.Id == id {
+			li := *v
+			err := mergePatch(&li, patch)
+			if err != nil {
+				return err
+			}
+			li.Id = v.Id
+			li.AppId = v.AppId
+			for _, vSibling := range app.LocalInterceptors {
+				if vSibling.Id != id && vSibling.Name == li.Name {
+					return errors.New("Local interceptor existed: " + li.Name)
 				}
 			}
+			err = li.Validate()
+			if err != nil {
+				return err
+			}
+			return this.CommitChange("LocalInterceptor", "update", &li)
 		}
 	}
-	return errors.New("Local interceptor not found: " + li.Name)
+	return errors.New("Local interceptor not found: " + id)
 }
 
 func (this *MasterData) AddRI(ri *RemoteInterceptor) error {
-	for iApp, vApp := range this.Apps {
+	for _, vApp := range this.Apps {
 		if vApp.Id == ri.AppId {
 			for _, vRi := range vApp.RemoteInterceptors {
 				if vRi.Name == ri.Name && vRi.AppId == ri.AppId {
 					return errors.New("Remote interceptor existed: " + ri.Name)
 				}
 			}
-			this.Apps[iApp].RemoteInterceptors = append(this.Apps[iApp].RemoteInterceptors, ri)
-			this.Version++
-			return masterData.Propagate()
+			return this.CommitChange("RemoteInterceptor", "add", ri)
 		}
 	}
 	return errors.New("App does not exist: " + ri.AppId)
 }
 func (this *MasterData) RemoveRI(id string, appId string) error {
-	for iApp, _ := range this.Apps {
-		if this.Apps[iApp].Id == appId {
-			for iRi, vRi := range this.Apps[iApp].RemoteInterceptors {
-				if vRi.Id == id && vRi.AppId == appId {
-					copy(this.Apps[iApp].RemoteInterceptors[iRi:], this.Apps[iApp].RemoteInterceptors[iRi+1:])
-					this.Apps[iApp].RemoteInterceptors[len(this.Apps[iApp].RemoteInterceptors)-1] = nil
-					this.Apps[iApp].RemoteInterceptors = this.Apps[iApp].RemoteInterceptors[:len(this.Apps[iApp].RemoteInterceptors)-1]
-					//					this.Apps[iApp].RemoteInterceptors = append(this.Apps[iApp].RemoteInterceptors[:iRi], this.Apps[iApp].RemoteInterceptors[iRi+1:]...)
-					this.Version++
-					return masterData.Propagate()
+	app := this.findApp(appId)
+	if app != nil {
+		for _, vRi := range app.RemoteInterceptors {
+			if vRi.Id == id {
+				change, err := this.removeRI(vRi)
+				if err != nil {
+					return err
 				}
+				return this.Commit(change)
 			}
 		}
 	}
-	return errors.New("Local interceptor not found: " + id)
+	return errors.New("Remote interceptor not found: " + id)
 }
-func (this *MasterData) UpdateRI(ri *RemoteInterceptor) error {
-	for iApp, vApp := range this.Apps {
-		if vApp.Id == ri.AppId {
-			for iRi, vRi := range this.Apps[iApp].RemoteInterceptors {
-				if vRi.Id == ri.Id && vRi.AppId == ri.AppId {
-					if ri.Name != "__not_set__" {
-						vRi.Name = ri.Name
-					}
-					if ri.Target != "__not_set__" {
-						vRi.Target = ri.Target
-					}
-					if ri.Method != "__not_set__" {
-						vRi.Method = ri.Method
-					}
-					if ri.Url != "__not_set__" {
-						vRi.Url = ri.Url
-					}
-					if ri.ActionType != "__not_set__" {
-						vRi.ActionType = ri.ActionType
-					}
-					if ri.Callback != "__not_set__" {
-						vRi.Callback = ri.Callback
-					}
-					if ri.Type != "__not_set__" {
-						vRi.Type = ri.Type
-					}
-					if ri.Note != "__not_set__" {
-						vRi.Note = ri.Note
-					}
-					this.Apps[iApp].RemoteInterceptors[iRi] = vRi
-					this.Version++
-					return masterData.Propagate()
+func (this *MasterData) removeRI(ri *RemoteInterceptor) (*MasterDataChange, error) {
+	return NewMasterDataChange("RemoteInterceptor", "remove", ri)
+}
+
+func (this *MasterData) UpdateRI(id string, appId string, patch []byte) error {
+	app := this.findApp(appId)
+	if app == nil {
+		return errors.New("App does not exist: " + appId)
+	}
+	for _, v := range app.RemoteInterceptors {
+		if v.Id == id {
+			ri := *v
+			err := mergePatch(&ri, patch)
+			if err != nil {
+				return err
+			}
+			ri.Id = v.Id
+			ri.AppId = v.AppId
+			for _, vSibling := range app.RemoteInterceptors {
+				if vSibling.Id != id && vSibling.Name == ri.Name {
+					return errors.New("Remote interceptor existed: " + ri.Name)
 				}
 			}
+			err = ri.Validate()
+			if err != nil {
+				return err
+			}
+			return this.CommitChange("RemoteInterceptor", "update", &ri)
 		}
 	}
-	return errors.New("Local interceptor not found: " + ri.Name)
+	return errors.New("Remote interceptor not found: " + id)
 }
 
+var apiNodesMutex = &sync.Mutex{}
+
 func AddApiNode(apiNode *ApiNode) error {
+	apiNodesMutex.Lock()
+	defer apiNodesMutex.Unlock()
 	for _, v := range apiNodes {
 		if v.Name == apiNode.Name {
 			return errors.New("API node existed: " + apiNode.Name)
@@ -673,6 +749,8 @@ func AddApiNode(apiNode *ApiNode) error {
 }
 
 func RemoveApiNode(remoteAddr string) error {
+	apiNodesMutex.Lock()
+	defer apiNodesMutex.Unlock()
 	index := -1
 	for i, v := range apiNodes {
 		if v.Name == remoteAddr {
@@ -690,17 +768,83 @@ func RemoveApiNode(remoteAddr string) error {
 	return nil
 }
 
-func (this *Query) Reload() error {
-	var app *App = nil
-	for iApp, vApp := range masterData.Apps {
-		if this.AppId == vApp.Id {
-			app = masterData.Apps[iApp]
-			break
+func AckApiNode(remoteAddr string, ack *MasterDataAck) error {
+	apiNodesMutex.Lock()
+	defer apiNodesMutex.Unlock()
+	for _, v := range apiNodes {
+		if v.Name == remoteAddr {
+			v.AppliedVersion = ack.Version
+			v.LastAck = time.Now().Unix()
+			v.AckError = ack.Error
+			return nil
 		}
 	}
+	return errors.New("API node not found: " + remoteAddr)
+}
 
-	if app == nil {
-		return errors.New("App not found: " + this.AppId)
+func ListApiNodes(version int64) string {
+	apiNodesMutex.Lock()
+	defer apiNodesMutex.Unlock()
+	var buffer bytes.Buffer
+	w := tabwriter.NewWriter(&buffer, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME\tSERVER\tIP4\tIP6\tPORT\tCOUNTRY\tREGION\tSUPER REGION\tAPPLIED\tLAG\tLAST ACK\tERROR")
+	for _, v := range apiNodes {
+		lastAck := "never"
+		if v.LastAck > 0 {
+			lastAck = time.Unix(v.LastAck, 0).Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\n", v.Id, v.Name, v.ServerName, v.ServerIP4, v.ServerIP6, v.ServerPort,
+			v.CountryCode, v.Region, v.SuperRegion, v.AppliedVersion, version-v.AppliedVersion, lastAck, v.AckError)
+	}
+	w.Flush()
+	buffer.WriteString(fmt.Sprintln("Master data version:", version))
+	return buffer.String()
+}
+
+// PublicApiNode is what clients discovering api nodes get to see.
+type PublicApiNode struct {
+	Id          string
+	ServerName  string
+	ServerIP4   string
+	ServerIP6   string
+	ServerPort  int64
+	CountryCode string
+	Region      string
+	SuperRegion string
+}
+
+// FindApiNodes returns the api nodes matching the non empty labels.
+func FindApiNodes(countryCode string, region string, superRegion string) []*PublicApiNode {
+	apiNodesMutex.Lock()
+	defer apiNodesMutex.Unlock()
+	found := []*PublicApiNode{}
+	for _, v := range apiNodes {
+		if (countryCode != "" && !strings.EqualFold(countryCode, v.CountryCode)) ||
+			(region != "" && !strings.EqualFold(region, v.Region)) ||
+			(superRegion != "" && !strings.EqualFold(superRegion, v.SuperRegion)) {
+			continue
+		}
+		found = append(found, &PublicApiNode{
+			Id:          v.Id,
+			ServerName:  v.ServerName,
+			ServerIP4:   v.ServerIP4,
+			ServerIP6:   v.ServerIP6,
+			ServerPort:  v.ServerPort,
+			CountryCode: v.CountryCode,
+			Region:      v.Region,
+			SuperRegion: v.SuperRegion,
+		})
+	}
+	return found
+}
+
+func (this *Query) Reload() error {
+	app, err := getMasterIndex().App(this.AppId)
+	if err != nil {
+		return err
+	}
+	if this.ScriptSource == ScriptSourceText {
+		return nil
 	}
 	if strings.TrimSpace(this.ScriptPath) == "" {
 		qFileFound := false
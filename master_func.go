@@ -4,67 +4,245 @@ package main
 import (
 	"encoding/json"
 	"errors"
-	"io/ioutil"
 	"log"
+	"net/http"
 	"sync"
+	"time"
 
 	"github.com/gorilla/websocket"
 )
 
-func processWsCommandMaster(conn *websocket.Conn, message []byte) error {
+func processWsCommandMaster(conn *SlaveConn, message []byte) error {
 	wsCommand := &Command{}
 	json.Unmarshal(message, wsCommand)
+	if wsCommand.Type != "WS_REGISTER" && !slaveConns.Registered(conn) {
+		return errors.New("Ignored " + wsCommand.Type + " from unregistered " + conn.RemoteAddr().String())
+	}
 	switch wsCommand.Type {
 	case "WS_REGISTER":
-		slaveService := &CliService{}
-		err := json.Unmarshal([]byte(wsCommand.Data), slaveService)
+		if conn.Id != "" {
+			return errors.New("Ignored repeated registration of " + conn.RemoteAddr().String())
+		}
+		if refuseIfNotMaster(conn) {
+			return errors.New("Refused registration of " + conn.RemoteAddr().String() + ", not master.")
+		}
+		registration := &SlaveRegistration{}
+		err := json.Unmarshal([]byte(wsCommand.Data), registration)
 		if err != nil {
 			conn.Close()
 			return err
 		}
 
-		if slaveService.Secret != service.Secret {
-			conn.WriteJSON("Failed to valid client secret.")
-			return errors.New("Failed to valid client secret.")
+		if !registration.Verify(takeChallenge(conn)) {
+			conn.SendAndClose("Failed to validate client secret.")
+			return errors.New("Failed to validate client secret: " + conn.RemoteAddr().String())
+		}
+
+		conn.Id = registration.Id
+		err = slaveConns.Add(conn)
+		if err != nil {
+			conn.Id = ""
+			conn.SendAndClose(err.Error())
+			return err
 		}
 
 		apiNode := &ApiNode{
-			Id:   slaveService.Id,
-			Name: conn.RemoteAddr().String(),
+			Id:          registration.Id,
+			Name:        conn.RemoteAddr().String(),
+			ServerName:  registration.ServerName,
+			ServerIP4:   registration.ServerIP4,
+			ServerIP6:   registration.ServerIP6,
+			ServerPort:  int64(registration.ServerPort),
+			CountryCode: registration.CountryCode,
+			Region:      registration.Region,
+			SuperRegion: registration.SuperRegion,
 		}
 		err = AddApiNode(apiNode)
 		if err != nil {
+			slaveConns.Remove(conn)
 			conn.Close()
 			return err
 		}
-
-		wsConns[slaveService.Id] = conn
-		conn.WriteJSON("OK")
+		conn.Send("OK")
 		log.Println(conn.RemoteAddr(), "connected.")
+		return masterData.SendTo(conn)
+	case "WS_ACK":
+		ack := &MasterDataAck{}
+		err := json.Unmarshal([]byte(wsCommand.Data), ack)
+		if err != nil {
+			return err
+		}
+		if ack.Error != "" {
+			log.Println(conn.RemoteAddr(), "failed to apply master data:", ack.Error)
+		}
+		return AckApiNode(conn.RemoteAddr().String(), ack)
+	case "WS_OP_RESULT":
+		result := &SlaveOpResult{}
+		err := json.Unmarshal([]byte(wsCommand.Data), result)
+		if err != nil {
+			return err
+		}
+		return deliverSlaveOpResult(conn, result)
+	case "WS_STATS":
+		report := &StatsReport{}
+		err := json.Unmarshal([]byte(wsCommand.Data), report)
+		if err != nil {
+			return err
+		}
+		MergeStats(report)
+	case "WS_LEASE_ACK":
+		sentAt, _ := wsCommand.Meta["sent_at"].(float64)
+		ackLease(time.Unix(0, int64(sentAt)))
+	case "WS_RESYNC":
+		// The slave missed a patch, or failed to apply one.
+		log.Println(conn.RemoteAddr(), "requested resync from version", wsCommand.Data)
+		return masterData.SendTo(conn)
 	}
 	return nil
 }
 
+// serveWs accepts the web socket connections of slaves.
+func serveWs(w http.ResponseWriter, r *http.Request) {
+	if !requireClientCert(w, r) {
+		return
+	}
+	conn, err := websocket.Upgrade(w, r, nil, 1024, 1024)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	slave := NewSlaveConn(conn)
+	err = issueChallenge(slave)
+	if err != nil {
+		log.Println(err)
+		slave.Close()
+		return
+	}
+	go func(c *SlaveConn) {
+		defer c.Close()
+		defer takeChallenge(c)
+		done := make(chan bool)
+		defer close(done)
+		keepAlive(c.conn, done)
+		for {
+			_, message, err := c.conn.ReadMessage()
+			if err != nil {
+				slaveConns.Remove(c)
+				err = RemoveApiNode(c.RemoteAddr().String())
+				if err != nil {
+					log.Println(err)
+				}
+				c.Close()
+				log.Println(c.RemoteAddr(), "dropped.")
+				break
+			}
+			extendReadDeadline(c.conn)
+			// Master to process command from client web socket channels.
+			err = processWsCommandMaster(c, message)
+			if err != nil {
+				log.Println(err)
+			}
+		}
+	}(slave)
+
+}
+
 var masterDataMutex = &sync.Mutex{}
 
-func (this *MasterData) Propagate() error {
+var errFenced = errors.New("Master is fenced, the standby does not acknowledge its lease. Change rejected.")
+
+// Commit records the changes that produce the next version of the master
+// data in the journal, applies them, and propagates them to all slaves as a
+// patch. Changes that do not apply, or cannot be written to the journal, are
+// rejected and leave the master data as it was.
+func (this *MasterData) Commit(changes ...*MasterDataChange) error {
 	masterDataMutex.Lock()
-	var err error
-	masterDataBytes, err := json.Marshal(this)
+	if isFenced() {
+		masterDataMutex.Unlock()
+		return errFenced
+	}
+	next := &MasterData{}
+	err := deepCopy(this, next)
+	if err == nil {
+		err = next.ApplyChanges(changes)
+	}
 	if err != nil {
+		masterDataMutex.Unlock()
 		return err
 	}
-	err = ioutil.WriteFile(service.DataFile, masterDataBytes, 0644)
+	entry := &JournalEntry{
+		Version: this.Version + 1,
+		Time:    time.Now().Unix(),
+		Changes: changes,
+	}
+	err = masterJournal.Append(entry)
+	if err != nil {
+		masterDataMutex.Unlock()
+		return errors.New("Failed to write the journal, change rejected: " + err.Error())
+	}
+	// Applied to the copy above, so applies to the master data too.
+	this.ApplyChanges(changes)
+	this.Version = entry.Version
+	this.reindex()
+	recordChangedVersions(changes, entry.Version)
+	// Patches are queued under the lock, so that slaves get them in version
+	// order.
+	entryBytes, err := json.Marshal(entry)
+	if err == nil {
+		broadcast(&Command{
+			Type: "WS_MASTER_PATCH",
+			Data: string(entryBytes),
+		})
+	}
+	masterDataMutex.Unlock()
+	forgetDbos(changes)
+	return err
+}
+
+func (this *MasterData) CommitChange(entity string, op string, data interface{}) error {
+	change, err := NewMasterDataChange(entity, op, data)
 	if err != nil {
 		return err
 	}
+	return this.Commit(change)
+}
+
+func (this *MasterData) masterDataCommand() (*Command, error) {
+	masterDataMutex.Lock()
+	masterDataBytes, err := json.Marshal(this)
 	masterDataMutex.Unlock()
-	masterDataCommand := &Command{
+	if err != nil {
+		return nil, err
+	}
+	return &Command{
 		Type: "WS_MASTER_DATA",
 		Data: string(masterDataBytes),
+	}, nil
+}
+
+// SendTo sends a full snapshot of the master data to one slave.
+func (this *MasterData) SendTo(conn *SlaveConn) error {
+	masterDataCommand, err := this.masterDataCommand()
+	if err != nil {
+		return err
 	}
-	for _, conn := range wsConns {
-		err = conn.WriteJSON(masterDataCommand)
+	return conn.Send(masterDataCommand)
+}
+
+// Propagate sends a full snapshot of the master data to all slaves.
+func (this *MasterData) Propagate() error {
+	masterDataCommand, err := this.masterDataCommand()
+	if err != nil {
+		return err
+	}
+	return broadcast(masterDataCommand)
+}
+
+// broadcast queues command for all slaves, without waiting for any of them.
+func broadcast(command *Command) error {
+	var err error
+	for _, conn := range slaveConns.All() {
+		err = conn.Send(command)
 		if err != nil {
 			log.Println(err)
 		}
@@ -0,0 +1,117 @@
+// master_index
+package main
+
+import (
+	"errors"
+	"log"
+	"sync/atomic"
+)
+
+// MasterDataIndex is a read only copy of the master data, indexed for the
+// lookups done while serving requests. A new index is built for every version
+// of the master data and swapped in atomically, so that a request sees either
+// the old or the new master data, never a mix of both.
+type MasterDataIndex struct {
+	Version    int64
+	appList    []*App
+	apps       map[string]*App
+	appsByName map[string]*App
+	dataNodes  map[string]*DataNode
+	queries    map[string]map[string]*Query
+	tokens     map[string]map[string]*Token
+}
+
+var masterIndex atomic.Value
+
+func NewMasterDataIndex(masterData *MasterData) (*MasterDataIndex, error) {
+	masterDataCopy := &MasterData{}
+	err := deepCopy(masterData, masterDataCopy)
+	if err != nil {
+		return nil, err
+	}
+	this := &MasterDataIndex{
+		Version:    masterDataCopy.Version,
+		appList:    masterDataCopy.Apps,
+		apps:       map[string]*App{},
+		appsByName: map[string]*App{},
+		dataNodes:  map[string]*DataNode{},
+		queries:    map[string]map[string]*Query{},
+		tokens:     map[string]map[string]*Token{},
+	}
+	for _, dataNode := range masterDataCopy.DataNodes {
+		this.dataNodes[dataNode.Id] = dataNode
+	}
+	for _, app := range masterDataCopy.Apps {
+		this.apps[app.Id] = app
+		this.appsByName[app.Name] = app
+		this.queries[app.Id] = map[string]*Query{}
+		for _, query := range app.Queries {
+			this.queries[app.Id][query.Name] = query
+		}
+		this.tokens[app.Id] = map[string]*Token{}
+		for _, token := range app.Tokens {
+			this.tokens[app.Id][token.Id] = token
+		}
+	}
+	return this, nil
+}
+
+// reindex publishes a new index of the master data. The caller holds
+// masterDataMutex.
+func (this *MasterData) reindex() {
+	index, err := NewMasterDataIndex(this)
+	if err != nil {
+		log.Println("Failed to index master data:", err)
+		return
+	}
+	masterIndex.Store(index)
+}
+
+// getMasterIndex returns the current index, which must not be modified.
+func getMasterIndex() *MasterDataIndex {
+	index, _ := masterIndex.Load().(*MasterDataIndex)
+	if index == nil {
+		return &MasterDataIndex{}
+	}
+	return index
+}
+
+func (this *MasterDataIndex) App(id string) (*App, error) {
+	if app, ok := this.apps[id]; ok {
+		return app, nil
+	}
+	return nil, errors.New("App not found: " + id)
+}
+
+// Apps returns the apps in the order of the master data.
+func (this *MasterDataIndex) Apps() []*App {
+	return this.appList
+}
+
+func (this *MasterDataIndex) AppByName(name string) (*App, error) {
+	if app, ok := this.appsByName[name]; ok {
+		return app, nil
+	}
+	return nil, errors.New("App not found: " + name)
+}
+
+func (this *MasterDataIndex) DataNode(id string) (*DataNode, error) {
+	if dataNode, ok := this.dataNodes[id]; ok {
+		return dataNode, nil
+	}
+	return nil, errors.New("Data node not found: " + id)
+}
+
+func (this *MasterDataIndex) Query(appId string, name string) (*Query, error) {
+	if query, ok := this.queries[appId][name]; ok {
+		return query, nil
+	}
+	return nil, errors.New("Query not found: " + name)
+}
+
+func (this *MasterDataIndex) Token(appId string, id string) (*Token, error) {
+	if token, ok := this.tokens[appId][id]; ok {
+		return token, nil
+	}
+	return nil, errors.New("Token not found: " + id)
+}
@@ -0,0 +1,132 @@
+// master_store
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// MasterDataStore persists snapshots of the master data. Mutations between
+// snapshots are kept in the journal, in the same database for the sqlite and
+// mysql stores.
+type MasterDataStore interface {
+	// Load reads the last snapshot into masterData, leaving it untouched if
+	// nothing has been stored yet.
+	Load(masterData *MasterData) error
+	Save(masterData *MasterData) error
+}
+
+var masterStore MasterDataStore
+
+func NewMasterDataStore(storeType string, ds string) (MasterDataStore, error) {
+	switch storeType {
+	case "", "file":
+		return &FileMasterDataStore{File: service.DataFile}, nil
+	case "sqlite":
+		if ds == "" {
+			ds = homeDir + "/.netdata/netdata_master.db"
+		}
+		return NewSqlMasterDataStore("sqlite3", ds)
+	case "mysql":
+		if ds == "" {
+			return nil, errors.New("Data store data source is required for mysql, format: user:pass@tcp(host:port)/db")
+		}
+		return NewSqlMasterDataStore("mysql", ds)
+	}
+	return nil, errors.New("Unknown data store: " + storeType)
+}
+
+// FileMasterDataStore keeps the master data as a json file.
+type FileMasterDataStore struct {
+	File string
+}
+
+func (this *FileMasterDataStore) Load(masterData *MasterData) error {
+	masterDataBytes, err := ioutil.ReadFile(this.File)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(masterDataBytes, masterData)
+}
+
+func (this *FileMasterDataStore) Save(masterData *MasterData) error {
+	masterDataBytes, err := json.Marshal(masterData)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(this.File, masterDataBytes, 0644)
+}
+
+// SqlMasterDataStore keeps the master data in a table of an embedded sqlite
+// database or a mysql data node, and replaces it in a transaction.
+type SqlMasterDataStore struct {
+	Driver string
+	Ds     string
+	db     *sql.DB
+}
+
+func NewSqlMasterDataStore(driver string, ds string) (*SqlMasterDataStore, error) {
+	db, err := sql.Open(driver, ds)
+	if err != nil {
+		return nil, err
+	}
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS nd_master_data (
+		ID INT NOT NULL PRIMARY KEY,
+		VERSION BIGINT NOT NULL,
+		DATA LONGTEXT NOT NULL,
+		UPDATED_AT BIGINT NOT NULL
+	)`)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &SqlMasterDataStore{
+		Driver: driver,
+		Ds:     ds,
+		db:     db,
+	}, nil
+}
+
+func (this *SqlMasterDataStore) Load(masterData *MasterData) error {
+	var data string
+	err := this.db.QueryRow("SELECT DATA FROM nd_master_data WHERE ID=1").Scan(&data)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal([]byte(data), masterData)
+}
+
+func (this *SqlMasterDataStore) Save(masterData *MasterData) error {
+	tx, err := this.db.Begin()
+	if err != nil {
+		return err
+	}
+	err = this.saveTx(tx, masterData)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (this *SqlMasterDataStore) saveTx(tx *sql.Tx, masterData *MasterData) error {
+	masterDataBytes, err := json.Marshal(masterData)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("REPLACE INTO nd_master_data (ID, VERSION, DATA, UPDATED_AT) VALUES (1, ?, ?, ?)",
+		masterData.Version, string(masterDataBytes), time.Now().Unix())
+	return err
+}
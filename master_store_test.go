@@ -0,0 +1,70 @@
+// master_store_test
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func testStoreRoundTrip(t *testing.T, store MasterDataStore) {
+	loaded := &MasterData{Version: 7}
+	err := store.Load(loaded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Version != 7 {
+		t.Fatalf("empty store loaded version %v, want the master data left as is", loaded.Version)
+	}
+
+	saved := testMasterData()
+	saved.Version = 1
+	err = store.Save(saved)
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved.Version = 2
+	saved.DataNodes[0].Host = "db.local"
+	err = store.Save(saved)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loaded = &MasterData{}
+	err = store.Load(loaded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded, saved) {
+		t.Errorf("loaded %+v, want the last saved %+v", loaded, saved)
+	}
+}
+
+func TestFileMasterDataStore(t *testing.T) {
+	testStoreRoundTrip(t, &FileMasterDataStore{File: filepath.Join(t.TempDir(), "netdata.json")})
+}
+
+func TestSqliteMasterDataStore(t *testing.T) {
+	store, err := NewSqlMasterDataStore("sqlite3", filepath.Join(t.TempDir(), "netdata_master.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.db.Close()
+	testStoreRoundTrip(t, store)
+}
+
+func TestNewMasterDataStore(t *testing.T) {
+	if _, err := NewMasterDataStore("mysql", ""); err == nil {
+		t.Error("mysql store without data source accepted")
+	}
+	if _, err := NewMasterDataStore("redis", ""); err == nil {
+		t.Error("unknown store accepted")
+	}
+	store, err := NewMasterDataStore("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := store.(*FileMasterDataStore); !ok {
+		t.Errorf("default store is %T, want a file store", store)
+	}
+}
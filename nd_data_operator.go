@@ -2,8 +2,9 @@
 package main
 
 import (
-	"errors"
 	"fmt"
+	"sync"
+	"time"
 
 	"github.com/elgs/gorest2"
 )
@@ -22,27 +23,32 @@ func NewDbo(ds, dbType string) gorest2.DataOperator {
 }
 
 func getQueryText(projectId, queryName string) (string, error) {
-	var app *App = nil
-	for iApp, vApp := range masterData.Apps {
-		if projectId == vApp.Id {
-			app = masterData.Apps[iApp]
-			break
-		}
+	index := getMasterIndex()
+	_, err := index.App(projectId)
+	if err != nil {
+		return "", err
 	}
-
-	if app == nil {
-		return "", errors.New("App not found: " + projectId)
+	query, err := index.Query(projectId, queryName)
+	if err != nil {
+		return "", err
 	}
+	return query.ScriptText, nil
+}
 
-	for _, vQuery := range app.Queries {
-		if vQuery.Name == queryName {
-			return vQuery.ScriptText, nil
-		}
+func (this *NdDataOperator) Exec(tableId string, params [][]interface{}, queryParams map[string]string, array bool, context map[string]interface{}) ([][]interface{}, error) {
+	start := time.Now()
+	result, err := this.exec(tableId, params, queryParams, array, context)
+	// Queries executed from the cli are not api traffic.
+	if cli, _ := context["cli"].(bool); cli {
+		return result, err
 	}
-	return "", errors.New("Query not found: " + queryName)
+	if appId, ok := context["app_id"].(string); ok {
+		recordQuery(appId, tableId, time.Since(start), result, err)
+	}
+	return result, err
 }
 
-func (this *NdDataOperator) Exec(tableId string, params [][]interface{}, queryParams map[string]string, array bool, context map[string]interface{}) ([][]interface{}, error) {
+func (this *NdDataOperator) exec(tableId string, params [][]interface{}, queryParams map[string]string, array bool, context map[string]interface{}) ([][]interface{}, error) {
 	projectId := context["app_id"].(string)
 	theCase := context["case"].(string)
 	sqlScript, err := getQueryText(projectId, tableId)
@@ -60,7 +66,16 @@ func (this *NdDataOperator) Exec(tableId string, params [][]interface{}, queryPa
 		return nil, err
 	}
 
+	// Queries executed from the cli may bypass the interceptors, and be rolled
+	// back at the end.
+	bypass, _ := context["bypass_interceptors"].(bool)
+	rollback, _ := context["rollback"].(bool)
+
 	globalDataInterceptors, globalSortedKeys := gorest2.GetGlobalDataInterceptors()
+	dataInterceptors, sortedKeys := gorest2.GetDataInterceptors(tableId)
+	if bypass {
+		globalSortedKeys, sortedKeys = nil, nil
+	}
 	for _, k := range globalSortedKeys {
 		globalDataInterceptor := globalDataInterceptors[k]
 		err := globalDataInterceptor.BeforeExec(tableId, scripts, &params, queryParams, array, db, context)
@@ -68,7 +83,6 @@ func (this *NdDataOperator) Exec(tableId string, params [][]interface{}, queryPa
 			return nil, err
 		}
 	}
-	dataInterceptors, sortedKeys := gorest2.GetDataInterceptors(tableId)
 	for _, k := range sortedKeys {
 		dataInterceptor := dataInterceptors[k]
 		if dataInterceptor != nil {
@@ -98,39 +112,60 @@ func (this *NdDataOperator) Exec(tableId string, params [][]interface{}, queryPa
 		globalDataInterceptor.AfterExec(tableId, scripts, &params, queryParams, array, db, context, &retArray)
 	}
 
-	tx.Commit()
+	if rollback {
+		tx.Rollback()
+	} else {
+		tx.Commit()
+	}
 
 	return retArray, err
 }
 
-func MakeGetDbo(dbType string, masterData *MasterData) func(id string) (gorest2.DataOperator, error) {
+// dboMutex guards gorest2.DboRegistry, filled while serving requests and
+// emptied of the apps whose master data has changed.
+var dboMutex = &sync.Mutex{}
+
+func forgetDbo(id string) {
+	dboMutex.Lock()
+	delete(gorest2.DboRegistry, id)
+	dboMutex.Unlock()
+}
+
+// forgetAllDbos empties gorest2.DboRegistry, and returns how many data
+// operators it held.
+func forgetAllDbos() int {
+	dboMutex.Lock()
+	defer dboMutex.Unlock()
+	count := len(gorest2.DboRegistry)
+	for id := range gorest2.DboRegistry {
+		delete(gorest2.DboRegistry, id)
+	}
+	return count
+}
+
+func countDbos() int {
+	dboMutex.Lock()
+	defer dboMutex.Unlock()
+	return len(gorest2.DboRegistry)
+}
+
+func MakeGetDbo(dbType string) func(id string) (gorest2.DataOperator, error) {
 	return func(id string) (gorest2.DataOperator, error) {
+		dboMutex.Lock()
+		defer dboMutex.Unlock()
 		ret := gorest2.DboRegistry[id]
 		if ret != nil {
 			return ret, nil
 		}
 
-		var app *App = nil
-		for _, a := range masterData.Apps {
-			if a.Id == id {
-				app = a
-				break
-			}
-		}
-		if app == nil {
-			return nil, errors.New("App not found: " + id)
-		}
-
-		var dn *DataNode = nil
-		for _, vDn := range masterData.DataNodes {
-			if app.DataNodeId == vDn.Id {
-				dn = vDn
-				break
-			}
+		index := getMasterIndex()
+		app, err := index.App(id)
+		if err != nil {
+			return nil, err
 		}
-
-		if dn == nil {
-			return nil, errors.New("Data node not found: " + app.DataNodeId)
+		dn, err := index.DataNode(app.DataNodeId)
+		if err != nil {
+			return nil, err
 		}
 
 		ds := fmt.Sprintf("%v:%v@tcp(%v:%v)/%v", app.DbName, id, dn.Host, dn.Port, "nd_"+app.DbName)
@@ -2,12 +2,15 @@
 package main
 
 import (
-	"crypto/tls"
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
 	"strings"
 	//	"time"
 
@@ -24,8 +27,12 @@ func httpRequest(url string, method string, data string, maxReadLimit int64) ([]
 		}
 	}()
 
+	tlsConfig, err := clientTLSConfig(false)
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
 	tr := &http.Transport{
-		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		TLSClientConfig: tlsConfig,
 	}
 	client := &http.Client{Transport: tr}
 	req, err := http.NewRequest(method, url, strings.NewReader(data))
@@ -52,6 +59,93 @@ func httpRequest(url string, method string, data string, maxReadLimit int64) ([]
 	return result, res.StatusCode, err
 }
 
+// writeFileAtomic writes data to a temporary file next to file, fsyncs it and
+// renames it over file, so that a crash never leaves a partially written file.
+func writeFileAtomic(file string, data []byte, perm os.FileMode) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(file), filepath.Base(file)+".tmp")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), perm)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
+}
+
+func deepCopy(from interface{}, to interface{}) error {
+	data, err := json.Marshal(from)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, to)
+}
+
+// mergePatch applies a json merge patch (RFC 7386) to target, a pointer to a
+// struct. A null in the patch resets the field to its zero value. Keys match
+// the fields case insensitively, as in json.Unmarshal.
+func mergePatch(target interface{}, patch []byte) error {
+	var patchDoc interface{}
+	err := json.Unmarshal(patch, &patchDoc)
+	if err != nil {
+		return err
+	}
+	if _, ok := patchDoc.(map[string]interface{}); !ok {
+		return errors.New("Patch must be a json object.")
+	}
+	var targetDoc interface{}
+	err = deepCopy(target, &targetDoc)
+	if err != nil {
+		return err
+	}
+	mergedBytes, err := json.Marshal(mergeJson(targetDoc, patchDoc))
+	if err != nil {
+		return err
+	}
+	merged := reflect.New(reflect.TypeOf(target).Elem())
+	err = json.Unmarshal(mergedBytes, merged.Interface())
+	if err != nil {
+		return err
+	}
+	reflect.ValueOf(target).Elem().Set(merged.Elem())
+	return nil
+}
+
+func mergeJson(target interface{}, patch interface{}) interface{} {
+	patchMap, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	targetMap, ok := target.(map[string]interface{})
+	if !ok {
+		targetMap = map[string]interface{}{}
+	}
+	for key, value := range patchMap {
+		for targetKey := range targetMap {
+			if targetKey != key && strings.EqualFold(targetKey, key) {
+				targetMap[key] = targetMap[targetKey]
+				delete(targetMap, targetKey)
+			}
+		}
+		if value == nil {
+			delete(targetMap, key)
+		} else {
+			targetMap[key] = mergeJson(targetMap[key], value)
+		}
+	}
+	return targetMap
+}
+
 func batchExecuteTx(tx *sql.Tx, db *sql.DB, script *string, scriptParams map[string]string, params [][]interface{}, array bool, theCase string, replaceContext map[string]string) ([][]interface{}, error) {
 	ret := [][]interface{}{}
 
@@ -0,0 +1,47 @@
+// ndutils_test
+package main
+
+import (
+	"testing"
+)
+
+func TestMergePatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		patch   string
+		want    DataNode
+		wantErr bool
+	}{
+		{"empty patch", `{}`, DataNode{Id: "dn1", Name: "dn1", Host: "localhost", Port: 3306, Note: "note"}, false},
+		{"set field", `{"Host":"db.local"}`, DataNode{Id: "dn1", Name: "dn1", Host: "db.local", Port: 3306, Note: "note"}, false},
+		{"case insensitive key", `{"port":3307}`, DataNode{Id: "dn1", Name: "dn1", Host: "localhost", Port: 3307, Note: "note"}, false},
+		{"null clears", `{"Note":null}`, DataNode{Id: "dn1", Name: "dn1", Host: "localhost", Port: 3306}, false},
+		{"null clears case insensitive key", `{"note":null,"port":null}`, DataNode{Id: "dn1", Name: "dn1", Host: "localhost"}, false},
+		{"empty string", `{"Note":""}`, DataNode{Id: "dn1", Name: "dn1", Host: "localhost", Port: 3306}, false},
+		{"not an object", `["Host"]`, DataNode{}, true},
+		{"wrong type", `{"Port":"x"}`, DataNode{}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dataNode := &DataNode{Id: "dn1", Name: "dn1", Host: "localhost", Port: 3306, Note: "note"}
+			err := mergePatch(dataNode, []byte(test.patch))
+			if (err != nil) != test.wantErr {
+				t.Fatalf("err = %v, want error %v", err, test.wantErr)
+			}
+			if !test.wantErr && *dataNode != test.want {
+				t.Errorf("data node = %+v, want %+v", *dataNode, test.want)
+			}
+		})
+	}
+}
+
+func TestMergePatchNested(t *testing.T) {
+	app := &App{Id: "a1", Name: "app1", Queries: []*Query{{Id: "q1"}}}
+	err := mergePatch(app, []byte(`{"Note":"x","Queries":null}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if app.Note != "x" || app.Queries != nil || app.Name != "app1" {
+		t.Errorf("app = %+v", app)
+	}
+}
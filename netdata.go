@@ -2,19 +2,19 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
-	"math"
 	"net/http"
 	"os"
 	"os/signal"
 	"os/user"
 	"strings"
 	"syscall"
+	"time"
 
 	"github.com/elgs/gorest2"
-	"github.com/elgs/gostrgen"
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/gorilla/websocket"
 	"github.com/satori/go.uuid"
@@ -23,7 +23,7 @@ import (
 
 var slaveConn *websocket.Conn
 
-var wsConns = make(map[string]*websocket.Conn)
+var slaveConns = NewSlaveRegistry()
 var masterData MasterData
 var apiNodes []*ApiNode
 var pwd string
@@ -69,6 +69,10 @@ func main() {
 	app.Usage = "An SQL backend for the web."
 	app.Version = "0.0.1"
 
+	app.Flags = append(service.TlsFlags(), cli.StringFlag{
+		Name:  "context",
+		Usage: "cli context to use instead of the current one",
+	})
 	app.Commands = []cli.Command{
 		{
 			Name:    "service",
@@ -82,65 +86,50 @@ func main() {
 					Flags:   service.Flags(),
 					Action: func(c *cli.Context) error {
 						service.LoadConfigs(c)
-						if _, err := os.Stat(service.DataFile); os.IsNotExist(err) {
-							fmt.Println(err)
-						} else {
-							masterDataBytes, err := ioutil.ReadFile(service.DataFile)
+						err = checkHeartbeat()
+						if err != nil {
+							return err
+						}
+						err = openLogFile()
+						if err != nil {
+							return err
+						}
+						gorest2.GetDbo = MakeGetDbo("mysql")
+						go PushStats(time.Duration(service.StatsInterval) * time.Second)
+
+						if len(strings.TrimSpace(service.Master)) > 0 {
+							// serve the cached master data until the master sends fresh data if slave
+							err = loadSlaveCache()
 							if err != nil {
-								return err
+								log.Println("Failed to load cached master data:", err)
 							}
-							err = json.Unmarshal(masterDataBytes, &masterData)
+							setStale(true)
+							reconnectBackoff.Max = time.Duration(service.ReconnectMax) * time.Second
+							go RegisterToMaster(wsDrop)
+						} else {
+							masterStore, err = NewMasterDataStore(service.DataStore, service.DataStoreDs)
 							if err != nil {
 								return err
 							}
-						}
-
-						gorest2.GetDbo = MakeGetDbo("mysql", &masterData)
-
-						if len(strings.TrimSpace(service.Master)) > 0 {
-							// load data from master if slave
-							if RegisterToMaster(wsDrop) != nil {
+							err = masterStore.Load(&masterData)
+							if err != nil {
 								return err
 							}
-						} else {
-							// load data from data file if master
-							StartJobs()
-							gorest2.RegisterHandler("/sys/ws", func(w http.ResponseWriter, r *http.Request) {
-								conn, err := websocket.Upgrade(w, r, nil, 1024, 1024)
+							masterData.reindex()
+							gorest2.RegisterHandler("/sys/ws", serveWs)
+							if service.Standby || peerIsMaster() {
+								// follow the active master until its lease expires
+								log.Println("Starting as standby of:", service.Peer)
+								go WatchLease()
+								reconnectBackoff.Max = time.Duration(service.ReconnectMax) * time.Second
+								RegisterToMaster(wsDrop)
+							} else {
+								// replay the journal on top of the data file if master
+								err = startMaster(false)
 								if err != nil {
-									http.Error(w, err.Error(), http.StatusInternalServerError)
-									return
+									return err
 								}
-								go func(c *websocket.Conn) {
-									defer c.Close()
-									for {
-										_, message, err := c.ReadMessage()
-										if err != nil {
-											err = RemoveApiNode(c.RemoteAddr().String())
-											if err != nil {
-												log.Println(err)
-											}
-											err = c.Close()
-											if err != nil {
-												log.Println(err)
-											}
-											log.Println(c.RemoteAddr(), "dropped.")
-											for k, v := range wsConns {
-												if v == c {
-													delete(wsConns, k)
-													break
-												}
-											}
-											break
-										}
-										// Master to process command from client web socket channels.
-										err = processWsCommandMaster(c, message)
-										if err != nil {
-											log.Println(err)
-										}
-									}
-								}(conn)
-							})
+							}
 						}
 						// shutdown
 						gorest2.RegisterHandler("/sys/shutdown", func(w http.ResponseWriter, r *http.Request) {
@@ -152,12 +141,25 @@ func main() {
 						})
 						// cli
 						gorest2.RegisterHandler("/sys/cli", func(w http.ResponseWriter, r *http.Request) {
+							if !requireClientCert(w, r) {
+								return
+							}
 							res, err := ioutil.ReadAll(r.Body)
 							if err != nil {
 								fmt.Fprint(w, err.Error())
 								return
 							}
-							if service.Master == "" {
+							cliCommand := &Command{}
+							json.Unmarshal(res, cliCommand)
+							if cliCommand.Type == "CLI_SHOW_ROLE" {
+								if cliCommand.Secret != service.Secret {
+									fmt.Fprint(w, "Failed to validate secret.")
+									return
+								}
+								fmt.Fprint(w, role())
+								return
+							}
+							if isMaster() {
 								// Master to process commands from cli interface.
 								result, err := processCliCommand(res)
 								if err != nil {
@@ -166,10 +168,13 @@ func main() {
 								}
 								fmt.Fprint(w, result)
 							} else {
-								cliCommand := &Command{}
-								json.Unmarshal(res, cliCommand)
 								// Slave to forward cli command to master.
-								response, err := sendCliCommand(service.Master, cliCommand, false)
+								node := getCurrentMaster()
+								if node == "" {
+									fmt.Fprint(w, "Not connected to a master.")
+									return
+								}
+								response, err := sendCliCommand(node, cliCommand, false)
 								if err != nil {
 									fmt.Fprint(w, err.Error())
 									return
@@ -179,11 +184,45 @@ func main() {
 							}
 						})
 
+						// api node discovery, public
+						gorest2.RegisterHandler("/sys/nodes", func(w http.ResponseWriter, r *http.Request) {
+							w.Header().Set("Content-Type", "application/json")
+							if !isMaster() {
+								node := getCurrentMaster()
+								if node == "" {
+									http.Error(w, "Not connected to a master.", http.StatusServiceUnavailable)
+									return
+								}
+								// Slave to forward the discovery to master.
+								result, status, err := httpRequest("https://"+node+"/sys/nodes?"+r.URL.RawQuery, "GET", "", -1)
+								if err != nil {
+									http.Error(w, err.Error(), http.StatusBadGateway)
+									return
+								}
+								w.WriteHeader(status)
+								w.Write(result)
+								return
+							}
+							query := r.URL.Query()
+							apiNodesBytes, err := json.Marshal(FindApiNodes(query.Get("country_code"), query.Get("region"), query.Get("super_region")))
+							if err != nil {
+								http.Error(w, err.Error(), http.StatusInternalServerError)
+								return
+							}
+							w.Write(apiNodesBytes)
+						})
+
 						gorest2.RegisterHandler("/api", gorest2.RestFunc)
 
 						// serve
 						serve(service)
 						<-done
+						if masterJournal != nil {
+							err = masterJournal.Compact(&masterData)
+							if err != nil {
+								fmt.Println(err)
+							}
+						}
 						fmt.Println("Bye!")
 						return nil
 					},
@@ -212,56 +251,8 @@ func main() {
 			Aliases: []string{"dn"},
 			Usage:   "data node commands",
 			Subcommands: []cli.Command{
-				{
-					Name:  "list",
-					Usage: "list all data nodes",
-					Flags: []cli.Flag{
-						cli.StringFlag{
-							Name:  "node, N",
-							Value: "127.0.0.1:2015",
-							Usage: "node url, format: host:port. 127.0.0.1:2015 if empty",
-						},
-						cli.BoolTFlag{
-							Name:  "full, f",
-							Usage: "show a full list of data nodes",
-						},
-						cli.BoolTFlag{
-							Name:  "compact, c",
-							Usage: "show a compact list of data nodes",
-						},
-						cli.StringFlag{
-							Name:        "secret, z",
-							Usage:       "secret password for server client communication.",
-							Destination: &service.Secret,
-						},
-					},
-					Action: func(c *cli.Context) error {
-						service.LoadSecrets(c)
-						node := c.String("node")
-						full := c.IsSet("full")
-						compact := c.IsSet("compact")
-						mode := "normal"
-						if compact {
-							mode = "compact"
-						} else if full {
-							mode = "full"
-						}
-						cliDnListCommand := &Command{
-							Type: "CLI_DN_LIST",
-							Data: mode,
-						}
-						response, err := sendCliCommand(node, cliDnListCommand, true)
-						if err != nil {
-							fmt.Println(err)
-							return err
-						}
-						output := string(response)
-						if output != "" {
-							fmt.Println(strings.TrimSpace(output))
-						}
-						return nil
-					},
-				},
+				listCommand("DN"),
+				describeCommand("DN"),
 				{
 					Name:  "add",
 					Usage: "add a new data node",
@@ -303,8 +294,11 @@ func main() {
 						},
 					},
 					Action: func(c *cli.Context) error {
-						service.LoadSecrets(c)
-						node := c.String("node")
+						if err := service.LoadSecrets(c); err != nil {
+							fmt.Println(err)
+							return err
+						}
+						node := cliNode(c)
 						id := strings.Replace(uuid.NewV4().String(), "-", "", -1)
 						dataNode := &DataNode{
 							Id:       id,
@@ -347,7 +341,7 @@ func main() {
 						},
 						cli.StringFlag{
 							Name:  "id, i",
-							Usage: "id of the data node",
+							Usage: "name or id of the data node",
 						},
 						cli.StringFlag{
 							Name:  "name, n",
@@ -374,6 +368,10 @@ func main() {
 							Name:  "note, t",
 							Usage: "a note for the data node",
 						},
+						cli.Int64Flag{
+							Name:  "expect, x",
+							Usage: "expected master data version, rejected if the entity has changed since",
+						},
 						cli.StringFlag{
 							Name:        "secret, z",
 							Usage:       "secret password for server client communication.",
@@ -381,42 +379,30 @@ func main() {
 						},
 					},
 					Action: func(c *cli.Context) error {
-						service.LoadSecrets(c)
-						node := c.String("node")
-						dataNode := &DataNode{
-							Id:       c.String("id"),
-							Name:     c.String("name"),
-							Host:     c.String("host"),
-							Port:     c.Int("port"),
-							Username: c.String("user"),
-							Password: c.String("pass"),
-							Note:     c.String("note"),
-						}
-						if !c.IsSet("name") {
-							dataNode.Name = "__not_set__"
-						}
-						if !c.IsSet("host") {
-							dataNode.Host = "__not_set__"
-						}
-						if !c.IsSet("port") {
-							dataNode.Port = -1
-						}
-						if !c.IsSet("user") {
-							dataNode.Username = "__not_set__"
-						}
-						if !c.IsSet("pass") {
-							dataNode.Password = "__not_set__"
+						if err := service.LoadSecrets(c); err != nil {
+							fmt.Println(err)
+							return err
 						}
-						if !c.IsSet("note") {
-							dataNode.Note = "__not_set__"
+						node := cliNode(c)
+						patch := map[string]interface{}{"Id": c.String("id")}
+						setPatchFields(c, patch, map[string]string{
+							"name": "Name",
+							"host": "Host",
+							"user": "Username",
+							"pass": "Password",
+							"note": "Note",
+						})
+						if c.IsSet("port") {
+							patch["Port"] = c.Int("port")
 						}
-						dataNodeJSONBytes, err := json.Marshal(dataNode)
+						dataNodeJSONBytes, err := json.Marshal(patch)
 						if err != nil {
 							fmt.Println(err)
 							return err
 						}
 						cliDnUpdateCommand := &Command{
 							Type: "CLI_DN_UPDATE",
+							Meta: cliMeta(c),
 							Data: string(dataNodeJSONBytes),
 						}
 						response, err := sendCliCommand(node, cliDnUpdateCommand, true)
@@ -442,7 +428,15 @@ func main() {
 						},
 						cli.StringFlag{
 							Name:  "id, i",
-							Usage: "id of the data node",
+							Usage: "name or id of the data node",
+						},
+						cli.BoolFlag{
+							Name:  "cascade",
+							Usage: "also remove the apps on the data node",
+						},
+						cli.Int64Flag{
+							Name:  "expect, x",
+							Usage: "expected master data version, rejected if the entity has changed since",
 						},
 						cli.StringFlag{
 							Name:        "secret, z",
@@ -451,11 +445,15 @@ func main() {
 						},
 					},
 					Action: func(c *cli.Context) error {
-						service.LoadSecrets(c)
-						node := c.String("node")
+						if err := service.LoadSecrets(c); err != nil {
+							fmt.Println(err)
+							return err
+						}
+						node := cliNode(c)
 						id := c.String("id")
 						cliDnRemoveCommand := &Command{
 							Type: "CLI_DN_REMOVE",
+							Meta: cliMeta(c),
 							Data: id,
 						}
 						response, err := sendCliCommand(node, cliDnRemoveCommand, true)
@@ -477,56 +475,8 @@ func main() {
 			Aliases: []string{"a"},
 			Usage:   "app commands",
 			Subcommands: []cli.Command{
-				{
-					Name:  "list",
-					Usage: "list all apps",
-					Flags: []cli.Flag{
-						cli.StringFlag{
-							Name:  "node, N",
-							Value: "127.0.0.1:2015",
-							Usage: "node url, format: host:port. 127.0.0.1:2015 if empty",
-						},
-						cli.BoolTFlag{
-							Name:  "full, f",
-							Usage: "show a full list of apps",
-						},
-						cli.BoolTFlag{
-							Name:  "compact, c",
-							Usage: "show a compact list of apps",
-						},
-						cli.StringFlag{
-							Name:        "secret, z",
-							Usage:       "secret password for server client communication.",
-							Destination: &service.Secret,
-						},
-					},
-					Action: func(c *cli.Context) error {
-						service.LoadSecrets(c)
-						node := c.String("node")
-						full := c.IsSet("full")
-						compact := c.IsSet("compact")
-						mode := "normal"
-						if compact {
-							mode = "compact"
-						} else if full {
-							mode = "full"
-						}
-						cliAppListCommand := &Command{
-							Type: "CLI_APP_LIST",
-							Data: mode,
-						}
-						response, err := sendCliCommand(node, cliAppListCommand, true)
-						if err != nil {
-							fmt.Println(err)
-							return err
-						}
-						output := string(response)
-						if output != "" {
-							fmt.Println(strings.TrimSpace(output))
-						}
-						return nil
-					},
-				},
+				listCommand("APP"),
+				describeCommand("APP"),
 				{
 					Name:  "add",
 					Usage: "add a new app",
@@ -542,7 +492,7 @@ func main() {
 						},
 						cli.StringFlag{
 							Name:  "datanode, d",
-							Usage: "data node id",
+							Usage: "data node name or id",
 						},
 						cli.StringFlag{
 							Name:  "note, t",
@@ -555,14 +505,16 @@ func main() {
 						},
 					},
 					Action: func(c *cli.Context) error {
-						service.LoadSecrets(c)
-						node := c.String("node")
+						if err := service.LoadSecrets(c); err != nil {
+							fmt.Println(err)
+							return err
+						}
+						node := cliNode(c)
 
 						name := c.String("name")
-						namePrefix := name[:int(math.Min(float64(len(name)), 8))]
 
 						id := strings.Replace(uuid.NewV4().String(), "-", "", -1)
-						dbName, err := gostrgen.RandGen(16-len(namePrefix), gostrgen.LowerDigit, "", "")
+						dbName, err := newAppDbName(name)
 						if err != nil {
 							return err
 						}
@@ -571,7 +523,7 @@ func main() {
 							Id:         id,
 							Name:       name,
 							DataNodeId: c.String("datanode"),
-							DbName:     namePrefix + dbName,
+							DbName:     dbName,
 							Note:       c.String("note"),
 						}
 						appJSONBytes, err := json.Marshal(app)
@@ -606,7 +558,7 @@ func main() {
 						},
 						cli.StringFlag{
 							Name:  "id, i",
-							Usage: "id of the app",
+							Usage: "name or id of the app",
 						},
 						cli.StringFlag{
 							Name:  "name, n",
@@ -614,12 +566,16 @@ func main() {
 						},
 						cli.StringFlag{
 							Name:  "datanode, d",
-							Usage: "data node id",
+							Usage: "data node name or id",
 						},
 						cli.StringFlag{
 							Name:  "note, t",
 							Usage: "a note for the app",
 						},
+						cli.Int64Flag{
+							Name:  "expect, x",
+							Usage: "expected master data version, rejected if the entity has changed since",
+						},
 						cli.StringFlag{
 							Name:        "secret, z",
 							Usage:       "secret password for server client communication.",
@@ -627,30 +583,25 @@ func main() {
 						},
 					},
 					Action: func(c *cli.Context) error {
-						service.LoadSecrets(c)
-						node := c.String("node")
-						app := &App{
-							Id:         c.String("id"),
-							Name:       c.String("name"),
-							DataNodeId: c.String("datanode"),
-							Note:       c.String("note"),
-						}
-						if !c.IsSet("name") {
-							app.Name = "__not_set__"
-						}
-						if !c.IsSet("datanode") {
-							app.DataNodeId = "__not_set__"
-						}
-						if !c.IsSet("note") {
-							app.Note = "__not_set__"
+						if err := service.LoadSecrets(c); err != nil {
+							fmt.Println(err)
+							return err
 						}
-						appJSONBytes, err := json.Marshal(app)
+						node := cliNode(c)
+						patch := map[string]interface{}{"Id": c.String("id")}
+						setPatchFields(c, patch, map[string]string{
+							"name":     "Name",
+							"datanode": "DataNodeId",
+							"note":     "Note",
+						})
+						appJSONBytes, err := json.Marshal(patch)
 						if err != nil {
 							fmt.Println(err)
 							return err
 						}
 						cliAppUpdateCommand := &Command{
 							Type: "CLI_APP_UPDATE",
+							Meta: cliMeta(c),
 							Data: string(appJSONBytes),
 						}
 						response, err := sendCliCommand(node, cliAppUpdateCommand, true)
@@ -676,7 +627,15 @@ func main() {
 						},
 						cli.StringFlag{
 							Name:  "id, i",
-							Usage: "id of the app",
+							Usage: "name or id of the app",
+						},
+						cli.BoolFlag{
+							Name:  "cascade",
+							Usage: "also remove the queries, jobs, tokens and interceptors of the app",
+						},
+						cli.Int64Flag{
+							Name:  "expect, x",
+							Usage: "expected master data version, rejected if the entity has changed since",
 						},
 						cli.StringFlag{
 							Name:        "secret, z",
@@ -685,11 +644,15 @@ func main() {
 						},
 					},
 					Action: func(c *cli.Context) error {
-						service.LoadSecrets(c)
-						node := c.String("node")
+						if err := service.LoadSecrets(c); err != nil {
+							fmt.Println(err)
+							return err
+						}
+						node := cliNode(c)
 						id := c.String("id")
 						cliAppRemoveCommand := &Command{
 							Type: "CLI_APP_REMOVE",
+							Meta: cliMeta(c),
 							Data: id,
 						}
 						response, err := sendCliCommand(node, cliAppRemoveCommand, true)
@@ -711,6 +674,9 @@ func main() {
 			Aliases: []string{"q"},
 			Usage:   "query commands",
 			Subcommands: []cli.Command{
+				listCommand("QUERY"),
+				describeCommand("QUERY"),
+				queryExecCommand(),
 				{
 					Name:  "add",
 					Usage: "add a new query",
@@ -726,11 +692,15 @@ func main() {
 						},
 						cli.StringFlag{
 							Name:  "app, a",
-							Usage: "app id",
+							Usage: "app name or id",
+						},
+						cli.StringFlag{
+							Name:  "file, f",
+							Usage: "local script file of the query, uploaded to the master",
 						},
 						cli.StringFlag{
 							Name:  "script, s",
-							Usage: "script path of the query",
+							Usage: "script path of the query on the master",
 						},
 						cli.StringFlag{
 							Name:  "mode, o",
@@ -747,17 +717,25 @@ func main() {
 						},
 					},
 					Action: func(c *cli.Context) error {
-						service.LoadSecrets(c)
-						node := c.String("node")
+						if err := service.LoadSecrets(c); err != nil {
+							fmt.Println(err)
+							return err
+						}
+						node := cliNode(c)
 						id := strings.Replace(uuid.NewV4().String(), "-", "", -1)
 						query := &Query{
 							Id:         id,
 							Name:       c.String("name"),
-							AppId:      c.String("app"),
+							AppId:      cliApp(c),
 							ScriptPath: c.String("script"),
 							Mode:       c.String("mode"),
 							Note:       c.String("note"),
 						}
+						err := setScriptFields(c, query)
+						if err != nil {
+							fmt.Println(err)
+							return err
+						}
 						queryJSONBytes, err := json.Marshal(query)
 						if err != nil {
 							fmt.Println(err)
@@ -790,7 +768,7 @@ func main() {
 						},
 						cli.StringFlag{
 							Name:  "id, i",
-							Usage: "id of the query",
+							Usage: "name or id of the query",
 						},
 						cli.StringFlag{
 							Name:  "name, n",
@@ -798,11 +776,15 @@ func main() {
 						},
 						cli.StringFlag{
 							Name:  "app, a",
-							Usage: "app id",
+							Usage: "app name or id",
+						},
+						cli.StringFlag{
+							Name:  "file, f",
+							Usage: "local script file of the query, uploaded to the master",
 						},
 						cli.StringFlag{
 							Name:  "script, s",
-							Usage: "script path of the query",
+							Usage: "script path of the query on the master",
 						},
 						cli.StringFlag{
 							Name:  "mode, o",
@@ -812,6 +794,10 @@ func main() {
 							Name:  "note, t",
 							Usage: "a note for the query",
 						},
+						cli.Int64Flag{
+							Name:  "expect, x",
+							Usage: "expected master data version, rejected if the entity has changed since",
+						},
 						cli.StringFlag{
 							Name:        "secret, z",
 							Usage:       "secret password for server client communication.",
@@ -819,35 +805,30 @@ func main() {
 						},
 					},
 					Action: func(c *cli.Context) error {
-						service.LoadSecrets(c)
-						node := c.String("node")
-						query := &Query{
-							Id:         c.String("id"),
-							Name:       c.String("name"),
-							AppId:      c.String("app"),
-							ScriptPath: c.String("script"),
-							Mode:       c.String("mode"),
-							Note:       c.String("note"),
-						}
-						if !c.IsSet("name") {
-							query.Name = "__not_set__"
-						}
-						if !c.IsSet("script") {
-							query.ScriptPath = "__not_set__"
-						}
-						if !c.IsSet("mode") {
-							query.Mode = "__not_set__"
+						if err := service.LoadSecrets(c); err != nil {
+							fmt.Println(err)
+							return err
 						}
-						if !c.IsSet("note") {
-							query.Note = "__not_set__"
+						node := cliNode(c)
+						patch := map[string]interface{}{"Id": c.String("id"), "AppId": cliApp(c)}
+						setPatchFields(c, patch, map[string]string{
+							"name": "Name",
+							"mode": "Mode",
+							"note": "Note",
+						})
+						err := setScriptFields(c, &patch)
+						if err != nil {
+							fmt.Println(err)
+							return err
 						}
-						queryJSONBytes, err := json.Marshal(query)
+						queryJSONBytes, err := json.Marshal(patch)
 						if err != nil {
 							fmt.Println(err)
 							return err
 						}
 						cliQueryUpdateCommand := &Command{
 							Type: "CLI_QUERY_UPDATE",
+							Meta: cliMeta(c),
 							Data: string(queryJSONBytes),
 						}
 						response, err := sendCliCommand(node, cliQueryUpdateCommand, true)
@@ -873,7 +854,7 @@ func main() {
 						},
 						cli.StringFlag{
 							Name:  "app, a",
-							Usage: "app id",
+							Usage: "app name or id",
 						},
 						cli.StringFlag{
 							Name:        "secret, z",
@@ -882,9 +863,12 @@ func main() {
 						},
 					},
 					Action: func(c *cli.Context) error {
-						service.LoadSecrets(c)
-						node := c.String("node")
-						appId := c.String("app")
+						if err := service.LoadSecrets(c); err != nil {
+							fmt.Println(err)
+							return err
+						}
+						node := cliNode(c)
+						appId := cliApp(c)
 						cliQueryReloadAllCommand := &Command{
 							Type: "CLI_QUERY_RELOAD_ALL",
 							Data: appId,
@@ -912,11 +896,19 @@ func main() {
 						},
 						cli.StringFlag{
 							Name:  "id, i",
-							Usage: "id of the query",
+							Usage: "name or id of the query",
 						},
 						cli.StringFlag{
 							Name:  "app, a",
-							Usage: "app id",
+							Usage: "app name or id",
+						},
+						cli.BoolFlag{
+							Name:  "cascade",
+							Usage: "also remove the interceptors calling or targeting the query",
+						},
+						cli.Int64Flag{
+							Name:  "expect, x",
+							Usage: "expected master data version, rejected if the entity has changed since",
 						},
 						cli.StringFlag{
 							Name:        "secret, z",
@@ -925,11 +917,14 @@ func main() {
 						},
 					},
 					Action: func(c *cli.Context) error {
-						service.LoadSecrets(c)
-						node := c.String("node")
+						if err := service.LoadSecrets(c); err != nil {
+							fmt.Println(err)
+							return err
+						}
+						node := cliNode(c)
 						query := &Query{
 							Id:    c.String("id"),
-							AppId: c.String("app"),
+							AppId: cliApp(c),
 						}
 						queryJSONBytes, err := json.Marshal(query)
 						if err != nil {
@@ -938,6 +933,7 @@ func main() {
 						}
 						cliQueryRemoveCommand := &Command{
 							Type: "CLI_QUERY_REMOVE",
+							Meta: cliMeta(c),
 							Data: string(queryJSONBytes),
 						}
 						response, err := sendCliCommand(node, cliQueryRemoveCommand, true)
@@ -959,6 +955,8 @@ func main() {
 			Aliases: []string{"j"},
 			Usage:   "job commands",
 			Subcommands: []cli.Command{
+				listCommand("JOB"),
+				describeCommand("JOB"),
 				{
 					Name:  "add",
 					Usage: "add a new job",
@@ -974,19 +972,27 @@ func main() {
 						},
 						cli.StringFlag{
 							Name:  "app, a",
-							Usage: "app id",
+							Usage: "app name or id",
 						},
 						cli.StringFlag{
 							Name:  "cron, c",
 							Usage: "cron expression of the job",
 						},
+						cli.StringFlag{
+							Name:  "file, f",
+							Usage: "local script file of the job, uploaded to the master",
+						},
+						cli.StringFlag{
+							Name:  "loopfile, F",
+							Usage: "local loop script file of the job, uploaded to the master",
+						},
 						cli.StringFlag{
 							Name:  "script, s",
-							Usage: "script path of the job",
+							Usage: "script path of the job on the master",
 						},
 						cli.StringFlag{
 							Name:  "loopscript, l",
-							Usage: "loop script path of the job",
+							Usage: "loop script path of the job on the master",
 						},
 						cli.IntFlag{
 							Name:  "auto, u",
@@ -1003,19 +1009,27 @@ func main() {
 						},
 					},
 					Action: func(c *cli.Context) error {
-						service.LoadSecrets(c)
-						node := c.String("node")
+						if err := service.LoadSecrets(c); err != nil {
+							fmt.Println(err)
+							return err
+						}
+						node := cliNode(c)
 						id := strings.Replace(uuid.NewV4().String(), "-", "", -1)
 						job := &Job{
 							Id:             id,
 							Name:           c.String("name"),
-							AppId:          c.String("app"),
+							AppId:          cliApp(c),
 							ScriptPath:     c.String("script"),
 							LoopScriptPath: c.String("loopscript"),
 							Cron:           c.String("cron"),
 							AutoStart:      c.Int("auto"),
 							Note:           c.String("note"),
 						}
+						err := setScriptFields(c, job)
+						if err != nil {
+							fmt.Println(err)
+							return err
+						}
 						jobJSONBytes, err := json.Marshal(job)
 						if err != nil {
 							fmt.Println(err)
@@ -1048,7 +1062,7 @@ func main() {
 						},
 						cli.StringFlag{
 							Name:  "id, i",
-							Usage: "id of the job",
+							Usage: "name or id of the job",
 						},
 						cli.StringFlag{
 							Name:  "name, n",
@@ -1056,19 +1070,27 @@ func main() {
 						},
 						cli.StringFlag{
 							Name:  "app, a",
-							Usage: "app id",
+							Usage: "app name or id",
 						},
 						cli.StringFlag{
 							Name:  "cron, c",
 							Usage: "cron expression of the job",
 						},
+						cli.StringFlag{
+							Name:  "file, f",
+							Usage: "local script file of the job, uploaded to the master",
+						},
+						cli.StringFlag{
+							Name:  "loopfile, F",
+							Usage: "local loop script file of the job, uploaded to the master",
+						},
 						cli.StringFlag{
 							Name:  "script, s",
-							Usage: "script path of the job",
+							Usage: "script path of the job on the master",
 						},
 						cli.StringFlag{
 							Name:  "loopscript, l",
-							Usage: "loop script path of the job",
+							Usage: "loop script path of the job on the master",
 						},
 						cli.IntFlag{
 							Name:  "auto, u",
@@ -1078,6 +1100,10 @@ func main() {
 							Name:  "note, t",
 							Usage: "a note for the job",
 						},
+						cli.Int64Flag{
+							Name:  "expect, x",
+							Usage: "expected master data version, rejected if the entity has changed since",
+						},
 						cli.StringFlag{
 							Name:        "secret, z",
 							Usage:       "secret password for server client communication.",
@@ -1085,43 +1111,33 @@ func main() {
 						},
 					},
 					Action: func(c *cli.Context) error {
-						service.LoadSecrets(c)
-						node := c.String("node")
-						job := &Job{
-							Id:             c.String("id"),
-							Name:           c.String("name"),
-							AppId:          c.String("app"),
-							ScriptPath:     c.String("script"),
-							LoopScriptPath: c.String("loopscript"),
-							Cron:           c.String("cron"),
-							AutoStart:      c.Int("auto"),
-							Note:           c.String("note"),
-						}
-						if !c.IsSet("name") {
-							job.Name = "__not_set__"
-						}
-						if !c.IsSet("script") {
-							job.ScriptPath = "__not_set__"
-						}
-						if !c.IsSet("loopscript") {
-							job.LoopScriptPath = "__not_set__"
-						}
-						if !c.IsSet("cron") {
-							job.Cron = "__not_set__"
+						if err := service.LoadSecrets(c); err != nil {
+							fmt.Println(err)
+							return err
 						}
-						if !c.IsSet("auto") {
-							job.AutoStart = -1
+						node := cliNode(c)
+						patch := map[string]interface{}{"Id": c.String("id"), "AppId": cliApp(c)}
+						setPatchFields(c, patch, map[string]string{
+							"name": "Name",
+							"cron": "Cron",
+							"note": "Note",
+						})
+						if c.IsSet("auto") {
+							patch["AutoStart"] = c.Int("auto")
 						}
-						if !c.IsSet("note") {
-							job.Note = "__not_set__"
+						err := setScriptFields(c, &patch)
+						if err != nil {
+							fmt.Println(err)
+							return err
 						}
-						jobJSONBytes, err := json.Marshal(job)
+						jobJSONBytes, err := json.Marshal(patch)
 						if err != nil {
 							fmt.Println(err)
 							return err
 						}
 						cliJobUpdateCommand := &Command{
 							Type: "CLI_JOB_UPDATE",
+							Meta: cliMeta(c),
 							Data: string(jobJSONBytes),
 						}
 						response, err := sendCliCommand(node, cliJobUpdateCommand, true)
@@ -1147,11 +1163,15 @@ func main() {
 						},
 						cli.StringFlag{
 							Name:  "id, i",
-							Usage: "id of the job",
+							Usage: "name or id of the job",
 						},
 						cli.StringFlag{
 							Name:  "app, a",
-							Usage: "app id",
+							Usage: "app name or id",
+						},
+						cli.Int64Flag{
+							Name:  "expect, x",
+							Usage: "expected master data version, rejected if the entity has changed since",
 						},
 						cli.StringFlag{
 							Name:        "secret, z",
@@ -1160,11 +1180,14 @@ func main() {
 						},
 					},
 					Action: func(c *cli.Context) error {
-						service.LoadSecrets(c)
-						node := c.String("node")
+						if err := service.LoadSecrets(c); err != nil {
+							fmt.Println(err)
+							return err
+						}
+						node := cliNode(c)
 						job := &Job{
 							Id:    c.String("id"),
-							AppId: c.String("app"),
+							AppId: cliApp(c),
 						}
 						jobJSONBytes, err := json.Marshal(job)
 						if err != nil {
@@ -1173,6 +1196,7 @@ func main() {
 						}
 						cliJobRemoveCommand := &Command{
 							Type: "CLI_JOB_REMOVE",
+							Meta: cliMeta(c),
 							Data: string(jobJSONBytes),
 						}
 						response, err := sendCliCommand(node, cliJobRemoveCommand, true)
@@ -1198,11 +1222,11 @@ func main() {
 						},
 						cli.StringFlag{
 							Name:  "id, i",
-							Usage: "id of the job",
+							Usage: "name or id of the job",
 						},
 						cli.StringFlag{
 							Name:  "app, a",
-							Usage: "app id",
+							Usage: "app name or id",
 						},
 						cli.StringFlag{
 							Name:        "secret, z",
@@ -1211,11 +1235,14 @@ func main() {
 						},
 					},
 					Action: func(c *cli.Context) error {
-						service.LoadSecrets(c)
-						node := c.String("node")
+						if err := service.LoadSecrets(c); err != nil {
+							fmt.Println(err)
+							return err
+						}
+						node := cliNode(c)
 						job := &Job{
 							Id:    c.String("id"),
-							AppId: c.String("app"),
+							AppId: cliApp(c),
 						}
 						jobJSONBytes, err := json.Marshal(job)
 						if err != nil {
@@ -1249,11 +1276,11 @@ func main() {
 						},
 						cli.StringFlag{
 							Name:  "id, i",
-							Usage: "id of the job",
+							Usage: "name or id of the job",
 						},
 						cli.StringFlag{
 							Name:  "app, a",
-							Usage: "app id",
+							Usage: "app name or id",
 						},
 						cli.StringFlag{
 							Name:        "secret, z",
@@ -1262,11 +1289,14 @@ func main() {
 						},
 					},
 					Action: func(c *cli.Context) error {
-						service.LoadSecrets(c)
-						node := c.String("node")
+						if err := service.LoadSecrets(c); err != nil {
+							fmt.Println(err)
+							return err
+						}
+						node := cliNode(c)
 						job := &Job{
 							Id:    c.String("id"),
-							AppId: c.String("app"),
+							AppId: cliApp(c),
 						}
 						jobJSONBytes, err := json.Marshal(job)
 						if err != nil {
@@ -1300,11 +1330,11 @@ func main() {
 						},
 						cli.StringFlag{
 							Name:  "id, i",
-							Usage: "id of the job",
+							Usage: "name or id of the job",
 						},
 						cli.StringFlag{
 							Name:  "app, a",
-							Usage: "app id",
+							Usage: "app name or id",
 						},
 						cli.StringFlag{
 							Name:        "secret, z",
@@ -1313,11 +1343,14 @@ func main() {
 						},
 					},
 					Action: func(c *cli.Context) error {
-						service.LoadSecrets(c)
-						node := c.String("node")
+						if err := service.LoadSecrets(c); err != nil {
+							fmt.Println(err)
+							return err
+						}
+						node := cliNode(c)
 						job := &Job{
 							Id:    c.String("id"),
-							AppId: c.String("app"),
+							AppId: cliApp(c),
 						}
 						jobJSONBytes, err := json.Marshal(job)
 						if err != nil {
@@ -1347,6 +1380,8 @@ func main() {
 			Aliases: []string{"t"},
 			Usage:   "token commands",
 			Subcommands: []cli.Command{
+				listCommand("TOKEN"),
+				describeCommand("TOKEN"),
 				{
 					Name:  "add",
 					Usage: "add a new token",
@@ -1362,7 +1397,7 @@ func main() {
 						},
 						cli.StringFlag{
 							Name:  "app, a",
-							Usage: "app id",
+							Usage: "app name or id",
 						},
 						cli.StringFlag{
 							Name:  "mode, o",
@@ -1383,9 +1418,12 @@ func main() {
 						},
 					},
 					Action: func(c *cli.Context) error {
-						service.LoadSecrets(c)
-						node := c.String("node")
-						appId := c.String("app")
+						if err := service.LoadSecrets(c); err != nil {
+							fmt.Println(err)
+							return err
+						}
+						node := cliNode(c)
+						appId := cliApp(c)
 						id := appId + strings.Replace(uuid.NewV4().String(), "-", "", -1)
 						token := &Token{
 							Id:     id,
@@ -1431,11 +1469,11 @@ func main() {
 						},
 						cli.StringFlag{
 							Name:  "id, i",
-							Usage: "id of the token",
+							Usage: "name or id of the token",
 						},
 						cli.StringFlag{
 							Name:  "app, a",
-							Usage: "app id",
+							Usage: "app name or id",
 						},
 						cli.StringFlag{
 							Name:  "mode, o",
@@ -1449,6 +1487,10 @@ func main() {
 							Name:  "note, t",
 							Usage: "a note for the token",
 						},
+						cli.Int64Flag{
+							Name:  "expect, x",
+							Usage: "expected master data version, rejected if the entity has changed since",
+						},
 						cli.StringFlag{
 							Name:        "secret, z",
 							Usage:       "secret password for server client communication.",
@@ -1456,35 +1498,26 @@ func main() {
 						},
 					},
 					Action: func(c *cli.Context) error {
-						service.LoadSecrets(c)
-						node := c.String("node")
-						token := &Token{
-							Id:     c.String("id"),
-							Name:   c.String("name"),
-							AppId:  c.String("app"),
-							Mode:   c.String("mode"),
-							Target: c.String("target"),
-							Note:   c.String("note"),
-						}
-						if !c.IsSet("name") {
-							token.Name = "__not_set__"
-						}
-						if !c.IsSet("mode") {
-							token.Mode = "__not_set__"
-						}
-						if !c.IsSet("target") {
-							token.Target = "__not_set__"
-						}
-						if !c.IsSet("note") {
-							token.Note = "__not_set__"
+						if err := service.LoadSecrets(c); err != nil {
+							fmt.Println(err)
+							return err
 						}
-						tokenJSONBytes, err := json.Marshal(token)
+						node := cliNode(c)
+						patch := map[string]interface{}{"Id": c.String("id"), "AppId": cliApp(c)}
+						setPatchFields(c, patch, map[string]string{
+							"name":   "Name",
+							"mode":   "Mode",
+							"target": "Target",
+							"note":   "Note",
+						})
+						tokenJSONBytes, err := json.Marshal(patch)
 						if err != nil {
 							fmt.Println(err)
 							return err
 						}
 						cliTokenUpdateCommand := &Command{
 							Type: "CLI_TOKEN_UPDATE",
+							Meta: cliMeta(c),
 							Data: string(tokenJSONBytes),
 						}
 						response, err := sendCliCommand(node, cliTokenUpdateCommand, true)
@@ -1510,11 +1543,15 @@ func main() {
 						},
 						cli.StringFlag{
 							Name:  "id, i",
-							Usage: "id of the token",
+							Usage: "name or id of the token",
 						},
 						cli.StringFlag{
 							Name:  "app, a",
-							Usage: "app id",
+							Usage: "app name or id",
+						},
+						cli.Int64Flag{
+							Name:  "expect, x",
+							Usage: "expected master data version, rejected if the entity has changed since",
 						},
 						cli.StringFlag{
 							Name:        "secret, z",
@@ -1523,11 +1560,14 @@ func main() {
 						},
 					},
 					Action: func(c *cli.Context) error {
-						service.LoadSecrets(c)
-						node := c.String("node")
+						if err := service.LoadSecrets(c); err != nil {
+							fmt.Println(err)
+							return err
+						}
+						node := cliNode(c)
 						token := &Token{
 							Id:    c.String("id"),
-							AppId: c.String("app"),
+							AppId: cliApp(c),
 						}
 						jobJSONBytes, err := json.Marshal(token)
 						if err != nil {
@@ -1535,6 +1575,7 @@ func main() {
 						}
 						cliTokenRemoveCommand := &Command{
 							Type: "CLI_TOKEN_REMOVE",
+							Meta: cliMeta(c),
 							Data: string(jobJSONBytes),
 						}
 						response, err := sendCliCommand(node, cliTokenRemoveCommand, true)
@@ -1554,6 +1595,8 @@ func main() {
 			Name:  "li",
 			Usage: "local interceptor commands",
 			Subcommands: []cli.Command{
+				listCommand("LI"),
+				describeCommand("LI"),
 				{
 					Name:  "add",
 					Usage: "add a new local interceptor",
@@ -1569,7 +1612,7 @@ func main() {
 						},
 						cli.StringFlag{
 							Name:  "app, a",
-							Usage: "app id",
+							Usage: "app name or id",
 						},
 						cli.StringFlag{
 							Name:  "target, g",
@@ -1594,13 +1637,16 @@ func main() {
 						},
 					},
 					Action: func(c *cli.Context) error {
-						service.LoadSecrets(c)
-						node := c.String("node")
+						if err := service.LoadSecrets(c); err != nil {
+							fmt.Println(err)
+							return err
+						}
+						node := cliNode(c)
 						id := strings.Replace(uuid.NewV4().String(), "-", "", -1)
 						li := &LocalInterceptor{
 							Id:       id,
 							Name:     c.String("name"),
-							AppId:    c.String("app"),
+							AppId:    cliApp(c),
 							Target:   c.String("target"),
 							Callback: c.String("callback"),
 							Type:     c.String("type"),
@@ -1636,7 +1682,7 @@ func main() {
 						},
 						cli.StringFlag{
 							Name:  "id, i",
-							Usage: "id of the local interceptor",
+							Usage: "name or id of the local interceptor",
 						},
 						cli.StringFlag{
 							Name:  "name, n",
@@ -1644,7 +1690,7 @@ func main() {
 						},
 						cli.StringFlag{
 							Name:  "app, a",
-							Usage: "app id",
+							Usage: "app name or id",
 						},
 						cli.StringFlag{
 							Name:  "target, g",
@@ -1662,6 +1708,10 @@ func main() {
 							Name:  "note, t",
 							Usage: "note for the local interceptor",
 						},
+						cli.Int64Flag{
+							Name:  "expect, x",
+							Usage: "expected master data version, rejected if the entity has changed since",
+						},
 						cli.StringFlag{
 							Name:        "secret, z",
 							Usage:       "secret password for server client communication.",
@@ -1669,38 +1719,26 @@ func main() {
 						},
 					},
 					Action: func(c *cli.Context) error {
-						service.LoadSecrets(c)
-						node := c.String("node")
-						li := &LocalInterceptor{
-							Id:       c.String("id"),
-							Name:     c.String("name"),
-							AppId:    c.String("app"),
-							Target:   c.String("target"),
-							Callback: c.String("callback"),
-							Type:     c.String("type"),
-							Note:     c.String("note"),
-						}
-						if !c.IsSet("name") {
-							li.Name = "__not_set__"
-						}
-						if !c.IsSet("target") {
-							li.Target = "__not_set__"
-						}
-						if !c.IsSet("callback") {
-							li.Callback = "__not_set__"
-						}
-						if !c.IsSet("type") {
-							li.Type = "__not_set__"
-						}
-						if !c.IsSet("note") {
-							li.Note = "__not_set__"
+						if err := service.LoadSecrets(c); err != nil {
+							fmt.Println(err)
+							return err
 						}
-						liJSONBytes, err := json.Marshal(li)
+						node := cliNode(c)
+						patch := map[string]interface{}{"Id": c.String("id"), "AppId": cliApp(c)}
+						setPatchFields(c, patch, map[string]string{
+							"name":     "Name",
+							"target":   "Target",
+							"callback": "Callback",
+							"type":     "Type",
+							"note":     "Note",
+						})
+						liJSONBytes, err := json.Marshal(patch)
 						if err != nil {
 							return err
 						}
 						cliLiUpdateCommand := &Command{
 							Type: "CLI_LI_UPDATE",
+							Meta: cliMeta(c),
 							Data: string(liJSONBytes),
 						}
 						response, err := sendCliCommand(node, cliLiUpdateCommand, true)
@@ -1725,12 +1763,16 @@ func main() {
 						},
 						cli.StringFlag{
 							Name:  "id, i",
-							Usage: "the id of the local interceptor",
+							Usage: "name or id of the local interceptor",
 						},
 						cli.StringFlag{
 							Name:  "app, a",
 							Usage: "app name",
 						},
+						cli.Int64Flag{
+							Name:  "expect, x",
+							Usage: "expected master data version, rejected if the entity has changed since",
+						},
 						cli.StringFlag{
 							Name:        "secret, z",
 							Usage:       "secret password for server client communication.",
@@ -1738,11 +1780,14 @@ func main() {
 						},
 					},
 					Action: func(c *cli.Context) error {
-						service.LoadSecrets(c)
-						node := c.String("node")
+						if err := service.LoadSecrets(c); err != nil {
+							fmt.Println(err)
+							return err
+						}
+						node := cliNode(c)
 						li := &LocalInterceptor{
 							Id:    c.String("id"),
-							AppId: c.String("app"),
+							AppId: cliApp(c),
 						}
 						liJSONBytes, err := json.Marshal(li)
 						if err != nil {
@@ -1750,6 +1795,7 @@ func main() {
 						}
 						cliLiRemoveCommand := &Command{
 							Type: "CLI_LI_REMOVE",
+							Meta: cliMeta(c),
 							Data: string(liJSONBytes),
 						}
 						response, err := sendCliCommand(node, cliLiRemoveCommand, true)
@@ -1769,6 +1815,8 @@ func main() {
 			Name:  "ri",
 			Usage: "remote interceptor commands",
 			Subcommands: []cli.Command{
+				listCommand("RI"),
+				describeCommand("RI"),
 				{
 					Name:  "add",
 					Usage: "add a new remote interceptor",
@@ -1784,7 +1832,7 @@ func main() {
 						},
 						cli.StringFlag{
 							Name:  "app, a",
-							Usage: "app id",
+							Usage: "app name or id",
 						},
 						cli.StringFlag{
 							Name:  "target, g",
@@ -1822,13 +1870,16 @@ func main() {
 						},
 					},
 					Action: func(c *cli.Context) error {
-						service.LoadSecrets(c)
-						node := c.String("node")
+						if err := service.LoadSecrets(c); err != nil {
+							fmt.Println(err)
+							return err
+						}
+						node := cliNode(c)
 						id := strings.Replace(uuid.NewV4().String(), "-", "", -1)
 						ri := &RemoteInterceptor{
 							Id:         id,
 							Name:       c.String("name"),
-							AppId:      c.String("app"),
+							AppId:      cliApp(c),
 							Target:     c.String("target"),
 							Method:     c.String("method"),
 							Url:        c.String("url"),
@@ -1867,7 +1918,7 @@ func main() {
 						},
 						cli.StringFlag{
 							Name:  "id, i",
-							Usage: "id of the remote interceptor",
+							Usage: "name or id of the remote interceptor",
 						},
 						cli.StringFlag{
 							Name:  "name, n",
@@ -1875,7 +1926,7 @@ func main() {
 						},
 						cli.StringFlag{
 							Name:  "app, a",
-							Usage: "app id",
+							Usage: "app name or id",
 						},
 						cli.StringFlag{
 							Name:  "target, g",
@@ -1899,13 +1950,17 @@ func main() {
 							Usage: "type of the remote interceptor",
 						},
 						cli.StringFlag{
-							Name:  "action, i",
+							Name:  "action, o",
 							Usage: "action type of the remote interceptor",
 						},
 						cli.StringFlag{
 							Name:  "note, t",
 							Usage: "note for the remote interceptor",
 						},
+						cli.Int64Flag{
+							Name:  "expect, x",
+							Usage: "expected master data version, rejected if the entity has changed since",
+						},
 						cli.StringFlag{
 							Name:        "secret, z",
 							Usage:       "secret password for server client communication.",
@@ -1913,50 +1968,29 @@ func main() {
 						},
 					},
 					Action: func(c *cli.Context) error {
-						service.LoadSecrets(c)
-						node := c.String("node")
-						ri := &RemoteInterceptor{
-							Id:         c.String("id"),
-							Name:       c.String("name"),
-							AppId:      c.String("app"),
-							Target:     c.String("target"),
-							Method:     c.String("method"),
-							Url:        c.String("url"),
-							Callback:   c.String("callback"),
-							Type:       c.String("type"),
-							ActionType: c.String("action"),
-							Note:       c.String("note"),
-						}
-						if !c.IsSet("name") {
-							ri.Name = "__not_set__"
-						}
-						if !c.IsSet("target") {
-							ri.Target = "__not_set__"
-						}
-						if !c.IsSet("method") {
-							ri.Method = "__not_set__"
-						}
-						if !c.IsSet("url") {
-							ri.Url = "__not_set__"
-						}
-						if !c.IsSet("action") {
-							ri.ActionType = "__not_set__"
-						}
-						if !c.IsSet("callback") {
-							ri.Callback = "__not_set__"
-						}
-						if !c.IsSet("type") {
-							ri.Type = "__not_set__"
-						}
-						if !c.IsSet("note") {
-							ri.Note = "__not_set__"
+						if err := service.LoadSecrets(c); err != nil {
+							fmt.Println(err)
+							return err
 						}
-						riJSONBytes, err := json.Marshal(ri)
+						node := cliNode(c)
+						patch := map[string]interface{}{"Id": c.String("id"), "AppId": cliApp(c)}
+						setPatchFields(c, patch, map[string]string{
+							"name":     "Name",
+							"target":   "Target",
+							"method":   "Method",
+							"url":      "Url",
+							"action":   "ActionType",
+							"callback": "Callback",
+							"type":     "Type",
+							"note":     "Note",
+						})
+						riJSONBytes, err := json.Marshal(patch)
 						if err != nil {
 							return err
 						}
 						cliRiUpdateCommand := &Command{
 							Type: "CLI_RI_UPDATE",
+							Meta: cliMeta(c),
 							Data: string(riJSONBytes),
 						}
 						response, err := sendCliCommand(node, cliRiUpdateCommand, true)
@@ -1981,11 +2015,15 @@ func main() {
 						},
 						cli.StringFlag{
 							Name:  "id, i",
-							Usage: "the id of the remote interceptor",
+							Usage: "name or id of the remote interceptor",
 						},
 						cli.StringFlag{
 							Name:  "app, a",
-							Usage: "app id",
+							Usage: "app name or id",
+						},
+						cli.Int64Flag{
+							Name:  "expect, x",
+							Usage: "expected master data version, rejected if the entity has changed since",
 						},
 						cli.StringFlag{
 							Name:        "secret, z",
@@ -1994,11 +2032,14 @@ func main() {
 						},
 					},
 					Action: func(c *cli.Context) error {
-						service.LoadSecrets(c)
-						node := c.String("node")
+						if err := service.LoadSecrets(c); err != nil {
+							fmt.Println(err)
+							return err
+						}
+						node := cliNode(c)
 						ri := &RemoteInterceptor{
 							Id:    c.String("id"),
-							AppId: c.String("app"),
+							AppId: cliApp(c),
 						}
 						riJSONBytes, err := json.Marshal(ri)
 						if err != nil {
@@ -2006,6 +2047,7 @@ func main() {
 						}
 						cliRiRemoveCommand := &Command{
 							Type: "CLI_RI_REMOVE",
+							Meta: cliMeta(c),
 							Data: string(riJSONBytes),
 						}
 						response, err := sendCliCommand(node, cliRiRemoveCommand, true)
@@ -2041,8 +2083,11 @@ func main() {
 						},
 					},
 					Action: func(c *cli.Context) error {
-						service.LoadSecrets(c)
-						node := c.String("node")
+						if err := service.LoadSecrets(c); err != nil {
+							fmt.Println(err)
+							return err
+						}
+						node := cliNode(c)
 						cliShowMasterCommand := &Command{
 							Type: "CLI_SHOW_MASTER",
 						}
@@ -2058,6 +2103,47 @@ func main() {
 						return nil
 					},
 				},
+				{
+					Name:  "stats",
+					Usage: "show request statistics of the cluster",
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  "node, N",
+							Value: "127.0.0.1:2015",
+							Usage: "node url, format: host:port. 127.0.0.1:2015 if empty",
+						},
+						cli.StringFlag{
+							Name:  "app, a",
+							Usage: "app name or id, all apps if empty",
+						},
+						cli.StringFlag{
+							Name:        "secret, z",
+							Usage:       "secret password for server client communication.",
+							Destination: &service.Secret,
+						},
+					},
+					Action: func(c *cli.Context) error {
+						if err := service.LoadSecrets(c); err != nil {
+							fmt.Println(err)
+							return err
+						}
+						node := cliNode(c)
+						cliShowStatsCommand := &Command{
+							Type: "CLI_SHOW_STATS",
+							Data: c.String("app"),
+						}
+						response, err := sendCliCommand(node, cliShowStatsCommand, true)
+						if err != nil {
+							fmt.Println(err)
+							return err
+						}
+						output := string(response)
+						if output != "" {
+							fmt.Println(strings.TrimSpace(output))
+						}
+						return nil
+					},
+				},
 				{
 					Name:  "slave",
 					Usage: "show slave api nodes info",
@@ -2074,8 +2160,11 @@ func main() {
 						},
 					},
 					Action: func(c *cli.Context) error {
-						service.LoadSecrets(c)
-						node := c.String("node")
+						if err := service.LoadSecrets(c); err != nil {
+							fmt.Println(err)
+							return err
+						}
+						node := cliNode(c)
 						cliShowApiNodesCommand := &Command{
 							Type: "CLI_SHOW_API_NODES",
 						}
@@ -2093,10 +2182,214 @@ func main() {
 				},
 			},
 		},
+		contextCommand(),
+		{
+			Name:  "slave",
+			Usage: "operational commands sent by the master to the slaves",
+			Subcommands: []cli.Command{
+				slaveOpCommand("flush", "drop the cached data operators, to reconnect to the data nodes"),
+				slaveOpCommand("reload", "reload the query files found on the slaves"),
+				slaveOpCommand("rotate-logs", "reopen the log file after it has been rotated"),
+				slaveOpCommand("drain", "refuse new api requests, before taking a slave down"),
+				slaveOpCommand("resume", "serve api requests again after a drain"),
+				slaveOpCommand("status", "report the status of the slaves"),
+			},
+		},
 		{
 			Name:  "master",
 			Usage: "master commands",
 			Subcommands: []cli.Command{
+				{
+					Name:  "history",
+					Usage: "list previous versions of the master data",
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  "node, N",
+							Value: "127.0.0.1:2015",
+							Usage: "node url, format: host:port. 127.0.0.1:2015 if empty",
+						},
+						cli.StringFlag{
+							Name:        "secret, z",
+							Usage:       "secret password for server client communication.",
+							Destination: &service.Secret,
+						},
+					},
+					Action: func(c *cli.Context) error {
+						if err := service.LoadSecrets(c); err != nil {
+							fmt.Println(err)
+							return err
+						}
+						node := cliNode(c)
+						cliMasterHistoryCommand := &Command{
+							Type: "CLI_MASTER_HISTORY",
+						}
+						response, err := sendCliCommand(node, cliMasterHistoryCommand, true)
+						if err != nil {
+							fmt.Println(err)
+							return err
+						}
+						output := string(response)
+						if output != "" {
+							fmt.Println(strings.TrimSpace(output))
+						}
+						return nil
+					},
+				},
+				{
+					Name:  "rollback",
+					Usage: "restore a previous version of the master data and propagate it to all slaves",
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  "node, N",
+							Value: "127.0.0.1:2015",
+							Usage: "node url, format: host:port. 127.0.0.1:2015 if empty",
+						},
+						cli.Int64Flag{
+							Name:  "version, v",
+							Usage: "version of the master data to restore",
+						},
+						cli.StringFlag{
+							Name:        "secret, z",
+							Usage:       "secret password for server client communication.",
+							Destination: &service.Secret,
+						},
+					},
+					Action: func(c *cli.Context) error {
+						if err := service.LoadSecrets(c); err != nil {
+							fmt.Println(err)
+							return err
+						}
+						node := cliNode(c)
+						cliMasterRollbackCommand := &Command{
+							Type: "CLI_MASTER_ROLLBACK",
+							Data: fmt.Sprint(c.Int64("version")),
+						}
+						response, err := sendCliCommand(node, cliMasterRollbackCommand, true)
+						if err != nil {
+							fmt.Println(err)
+							return err
+						}
+						output := string(response)
+						if output != "" {
+							fmt.Println(strings.TrimSpace(output))
+						}
+						return nil
+					},
+				},
+				{
+					Name:      "export",
+					Usage:     "write the master data to a directory, one file per data node and app, with queries and jobs as sql files",
+					ArgsUsage: "<dir>",
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  "node, N",
+							Value: "127.0.0.1:2015",
+							Usage: "node url, format: host:port. 127.0.0.1:2015 if empty",
+						},
+						cli.StringFlag{
+							Name:        "secret, z",
+							Usage:       "secret password for server client communication.",
+							Destination: &service.Secret,
+						},
+					},
+					Action: func(c *cli.Context) error {
+						if err := service.LoadSecrets(c); err != nil {
+							fmt.Println(err)
+							return err
+						}
+						node := cliNode(c)
+						dir := c.Args().First()
+						if dir == "" {
+							err := errors.New("Directory is required.")
+							fmt.Println(err)
+							return err
+						}
+						cliShowMasterCommand := &Command{
+							Type: "CLI_SHOW_MASTER",
+						}
+						response, err := sendCliCommand(node, cliShowMasterCommand, true)
+						if err != nil {
+							fmt.Println(err)
+							return err
+						}
+						exported := &MasterData{}
+						err = json.Unmarshal(response, exported)
+						if err != nil {
+							fmt.Println(err)
+							return err
+						}
+						err = exportMasterData(exported, dir)
+						if err != nil {
+							fmt.Println(err)
+							return err
+						}
+						fmt.Println("Exported master data version", exported.Version, "to", dir)
+						return nil
+					},
+				},
+				{
+					Name:      "import",
+					Usage:     "apply the master data exported to a directory, printing the changes",
+					ArgsUsage: "<dir>",
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  "node, N",
+							Value: "127.0.0.1:2015",
+							Usage: "node url, format: host:port. 127.0.0.1:2015 if empty",
+						},
+						cli.BoolFlag{
+							Name:  "dry-run",
+							Usage: "only print the changes",
+						},
+						cli.BoolFlag{
+							Name:  "prune",
+							Usage: "remove data nodes, apps and app entities missing from the directory",
+						},
+						cli.StringFlag{
+							Name:        "secret, z",
+							Usage:       "secret password for server client communication.",
+							Destination: &service.Secret,
+						},
+					},
+					Action: func(c *cli.Context) error {
+						if err := service.LoadSecrets(c); err != nil {
+							fmt.Println(err)
+							return err
+						}
+						node := cliNode(c)
+						dir := c.Args().First()
+						if dir == "" {
+							err := errors.New("Directory is required.")
+							fmt.Println(err)
+							return err
+						}
+						imported, err := importMasterData(dir)
+						if err != nil {
+							fmt.Println(err)
+							return err
+						}
+						importedBytes, err := json.Marshal(imported)
+						if err != nil {
+							fmt.Println(err)
+							return err
+						}
+						cliMasterImportCommand := &Command{
+							Type: "CLI_MASTER_IMPORT",
+							Data: string(importedBytes),
+							Meta: cliMeta(c),
+						}
+						response, err := sendCliCommand(node, cliMasterImportCommand, true)
+						if err != nil {
+							fmt.Println(err)
+							return err
+						}
+						output := string(response)
+						if output != "" {
+							fmt.Println(strings.TrimSpace(output))
+						}
+						return nil
+					},
+				},
 				{
 					Name:    "propagate",
 					Aliases: []string{"p"},
@@ -2114,8 +2407,11 @@ func main() {
 						},
 					},
 					Action: func(c *cli.Context) error {
-						service.LoadSecrets(c)
-						node := c.String("node")
+						if err := service.LoadSecrets(c); err != nil {
+							fmt.Println(err)
+							return err
+						}
+						node := cliNode(c)
 						cliShowMasterCommand := &Command{
 							Type: "CLI_PROPAGATE",
 						}
@@ -0,0 +1,324 @@
+// ops
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/satori/go.uuid"
+	"github.com/urfave/cli"
+)
+
+// Operational commands are sent by the master to one or all slaves in WS_OP,
+// and answered with a WS_OP_RESULT carrying the same Id.
+
+type SlaveOp struct {
+	Id     string
+	Op     string
+	Target string
+}
+
+type SlaveOpResult struct {
+	Id     string
+	NodeId string
+	Output string
+	Error  string
+}
+
+var slaveOps = map[string]func() (string, error){
+	"flush":       flushDbos,
+	"reload":      reloadQueryFiles,
+	"rotate-logs": rotateLogs,
+	"drain":       func() (string, error) { return setDraining(true) },
+	"resume":      func() (string, error) { return setDraining(false) },
+	"status":      slaveStatus,
+}
+
+var slaveOpTimeout = 10 * time.Second
+
+var pendingOpsMutex = &sync.Mutex{}
+var pendingOps = map[string]chan *SlaveOpResult{}
+
+// RunSlaveOp sends op to the slave with the target id, or to all slaves if
+// target is empty, and waits for their results.
+func RunSlaveOp(op string, target string) (string, error) {
+	if _, ok := slaveOps[op]; !ok {
+		return "", errors.New("Unknown slave operation: " + op)
+	}
+	slaveOp := &SlaveOp{
+		Id:     strings.Replace(uuid.NewV4().String(), "-", "", -1),
+		Op:     op,
+		Target: target,
+	}
+	slaveOpBytes, err := json.Marshal(slaveOp)
+	if err != nil {
+		return "", err
+	}
+	command := &Command{
+		Type: "WS_OP",
+		Data: string(slaveOpBytes),
+	}
+
+	conns := map[string]*SlaveConn{}
+	for _, conn := range slaveConns.All() {
+		if target == "" || target == conn.Id {
+			conns[conn.Id] = conn
+		}
+	}
+	if len(conns) == 0 {
+		if target != "" {
+			return "", errors.New("Slave not connected: " + target)
+		}
+		return "No slave connected.\n", nil
+	}
+
+	results := make(chan *SlaveOpResult, len(conns))
+	pendingOpsMutex.Lock()
+	pendingOps[slaveOp.Id] = results
+	pendingOpsMutex.Unlock()
+	defer func() {
+		pendingOpsMutex.Lock()
+		delete(pendingOps, slaveOp.Id)
+		pendingOpsMutex.Unlock()
+	}()
+
+	answers := map[string]*SlaveOpResult{}
+	for id, conn := range conns {
+		err := conn.Send(command)
+		if err != nil {
+			answers[id] = &SlaveOpResult{Id: slaveOp.Id, NodeId: id, Error: err.Error()}
+		}
+	}
+	timeout := time.After(slaveOpTimeout)
+	for len(answers) < len(conns) {
+		select {
+		case result := <-results:
+			if _, ok := conns[result.NodeId]; ok {
+				answers[result.NodeId] = result
+			}
+		case <-timeout:
+			for id := range conns {
+				if _, ok := answers[id]; !ok {
+					answers[id] = &SlaveOpResult{Id: slaveOp.Id, NodeId: id, Error: "No acknowledgement within " + slaveOpTimeout.String()}
+				}
+			}
+		}
+	}
+
+	var buffer bytes.Buffer
+	w := tabwriter.NewWriter(&buffer, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tRESULT")
+	ids := []string{}
+	for id := range conns {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		result := answers[id]
+		if result.Error != "" {
+			fmt.Fprintf(w, "%v\tERROR: %v\n", id, result.Error)
+		} else {
+			fmt.Fprintf(w, "%v\t%v\n", id, result.Output)
+		}
+	}
+	w.Flush()
+	return buffer.String(), nil
+}
+
+// deliverSlaveOpResult hands a result received from a slave on conn to the
+// waiting RunSlaveOp, as the result of the slave registered on conn.
+func deliverSlaveOpResult(conn *SlaveConn, result *SlaveOpResult) error {
+	result.NodeId = conn.Id
+	pendingOpsMutex.Lock()
+	results, ok := pendingOps[result.Id]
+	pendingOpsMutex.Unlock()
+	if !ok {
+		return errors.New("Late or unknown slave operation result: " + result.Id)
+	}
+	select {
+	case results <- result:
+	default:
+	}
+	return nil
+}
+
+// runSlaveOp executes an operation on the slave and acknowledges it.
+func runSlaveOp(conn *websocket.Conn, data string) error {
+	slaveOp := &SlaveOp{}
+	err := json.Unmarshal([]byte(data), slaveOp)
+	if err != nil {
+		return err
+	}
+	result := &SlaveOpResult{
+		Id:     slaveOp.Id,
+		NodeId: service.Id,
+	}
+	if f, ok := slaveOps[slaveOp.Op]; ok {
+		output, err := f()
+		result.Output = output
+		if err != nil {
+			result.Error = err.Error()
+		}
+	} else {
+		result.Error = "Unknown slave operation: " + slaveOp.Op
+	}
+	log.Println("Slave operation", slaveOp.Op, result.Output, result.Error)
+	resultBytes, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	return writeToMaster(conn, &Command{
+		Type: "WS_OP_RESULT",
+		Data: string(resultBytes),
+	})
+}
+
+func flushDbos() (string, error) {
+	count := forgetAllDbos()
+	return fmt.Sprint("Flushed ", count, " data operators."), nil
+}
+
+// reloadQueryFiles reads the query files found on this slave over the query
+// texts received from the master.
+func reloadQueryFiles() (string, error) {
+	masterDataMutex.Lock()
+	defer masterDataMutex.Unlock()
+	reloaded, total := 0, 0
+	for _, app := range masterData.Apps {
+		for _, query := range app.Queries {
+			if query.ScriptSource == ScriptSourceText {
+				continue
+			}
+			total++
+			reloadedQuery := *query
+			if reloadedQuery.Reload() == nil {
+				*query = reloadedQuery
+				reloaded++
+			}
+		}
+	}
+	masterData.reindex()
+	return fmt.Sprint("Reloaded ", reloaded, " of ", total, " query files."), nil
+}
+
+var logFile *os.File
+
+// openLogFile sends the log to service.LogFile, or reopens it after it has
+// been rotated.
+func openLogFile() error {
+	if strings.TrimSpace(service.LogFile) == "" {
+		return nil
+	}
+	f, err := os.OpenFile(service.LogFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	log.SetOutput(f)
+	if logFile != nil {
+		logFile.Close()
+	}
+	logFile = f
+	return nil
+}
+
+func rotateLogs() (string, error) {
+	if strings.TrimSpace(service.LogFile) == "" {
+		return "Logging to stderr, nothing to rotate.", nil
+	}
+	err := openLogFile()
+	if err != nil {
+		return "", err
+	}
+	return "Reopened " + service.LogFile, nil
+}
+
+var draining int32
+
+func isDraining() bool {
+	return atomic.LoadInt32(&draining) == 1
+}
+
+func setDraining(drain bool) (string, error) {
+	if drain {
+		atomic.StoreInt32(&draining, 1)
+		return "Draining, refusing new api requests.", nil
+	}
+	atomic.StoreInt32(&draining, 0)
+	return "Serving api requests.", nil
+}
+
+var startedAt = time.Now()
+
+func slaveStatus() (string, error) {
+	return fmt.Sprint("role=", role(),
+		" version=", getMasterIndex().Version,
+		" draining=", isDraining(),
+		" dbos=", countDbos(),
+		" goroutines=", runtime.NumGoroutine(),
+		" uptime=", time.Since(startedAt).Truncate(time.Second)), nil
+}
+
+// slaveOpCommand builds the cli command sending op to the slaves.
+func slaveOpCommand(op string, usage string) cli.Command {
+	return cli.Command{
+		Name:  op,
+		Usage: usage,
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "node, N",
+				Value: "127.0.0.1:2015",
+				Usage: "node url, format: host:port. 127.0.0.1:2015 if empty",
+			},
+			cli.StringFlag{
+				Name:  "target, T",
+				Usage: "id of the slave api node, all slaves if empty",
+			},
+			cli.StringFlag{
+				Name:        "secret, z",
+				Usage:       "secret password for server client communication.",
+				Destination: &service.Secret,
+			},
+		},
+		Action: func(c *cli.Context) error {
+			if err := service.LoadSecrets(c); err != nil {
+				fmt.Println(err)
+				return err
+			}
+			node := cliNode(c)
+			slaveOpBytes, err := json.Marshal(&SlaveOp{
+				Op:     op,
+				Target: c.String("target"),
+			})
+			if err != nil {
+				fmt.Println(err)
+				return err
+			}
+			cliSlaveOpCommand := &Command{
+				Type: "CLI_SLAVE_OP",
+				Data: string(slaveOpBytes),
+			}
+			response, err := sendCliCommand(node, cliSlaveOpCommand, true)
+			if err != nil {
+				fmt.Println(err)
+				return err
+			}
+			output := string(response)
+			if output != "" {
+				fmt.Println(strings.TrimSpace(output))
+			}
+			return nil
+		},
+	}
+}
@@ -0,0 +1,309 @@
+// query_exec
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/elgs/gorest2"
+	"github.com/urfave/cli"
+)
+
+// QueryExec runs a query of an app on the master from the cli, through the
+// same data operator as the api, in CLI_QUERY_EXEC.
+type QueryExec struct {
+	Id    string
+	AppId string
+	// Params is either one list of parameters or a list of them.
+	Params       json.RawMessage
+	QueryParams  map[string]string
+	Array        bool
+	Case         string
+	Interceptors bool
+	ApiToken     string
+	UserToken    string
+	Rollback     bool
+	query        *Query
+}
+
+func (this *QueryExec) params() ([][]interface{}, error) {
+	params := [][]interface{}{}
+	if len(this.Params) == 0 || string(this.Params) == "null" {
+		return [][]interface{}{{}}, nil
+	}
+	err := json.Unmarshal(this.Params, &params)
+	if err == nil {
+		if len(params) == 0 {
+			params = append(params, []interface{}{})
+		}
+		return params, nil
+	}
+	param := []interface{}{}
+	err = json.Unmarshal(this.Params, &param)
+	if err != nil {
+		return nil, errors.New("Params must be a json array, or an array of them: " + err.Error())
+	}
+	return [][]interface{}{param}, nil
+}
+
+// resolveQueryExec reads the query exec of a CLI_QUERY_EXEC command and finds
+// its query, under the cli lock, which Run does not need.
+func resolveQueryExec(cliCommand *Command) (*QueryExec, error) {
+	cliMutex.Lock()
+	defer cliMutex.Unlock()
+	err := resolveRefs(cliCommand)
+	if err != nil {
+		return nil, err
+	}
+	queryExec := &QueryExec{}
+	err = json.Unmarshal([]byte(cliCommand.Data), queryExec)
+	if err != nil {
+		return nil, err
+	}
+	if app, err := getMasterIndex().App(queryExec.AppId); err == nil {
+		for _, v := range app.Queries {
+			if v.Id == queryExec.Id {
+				queryExec.query = v
+				break
+			}
+		}
+	}
+	if queryExec.query == nil {
+		return nil, errors.New("Query not found: " + queryExec.Id)
+	}
+	return queryExec, nil
+}
+
+// Run executes the query, and returns the result sets of every statement for
+// every set of parameters as json.
+func (this *QueryExec) Run() (string, error) {
+	params, err := this.params()
+	if err != nil {
+		return "", err
+	}
+	if this.QueryParams == nil {
+		this.QueryParams = map[string]string{}
+	}
+	dbo, err := gorest2.GetDbo(this.AppId)
+	if err != nil {
+		return "", err
+	}
+	context := map[string]interface{}{
+		"app_id":              this.AppId,
+		"case":                this.Case,
+		"client_ip":           "127.0.0.1",
+		"bypass_interceptors": !this.Interceptors,
+		"rollback":            this.Rollback,
+		"cli":                 true,
+	}
+	if this.ApiToken != "" {
+		context["api_token"] = this.ApiToken
+	}
+	if this.UserToken != "" {
+		context["user_token"] = this.UserToken
+	}
+	result, err := dbo.Exec(this.query.Name, params, this.QueryParams, this.Array, context)
+	if err != nil {
+		return "", err
+	}
+	resultBytes, err := json.Marshal(result)
+	if err != nil {
+		return "", err
+	}
+	return string(resultBytes), nil
+}
+
+// renderResultSets prints the result of each statement, for each set of
+// parameters, in turn.
+func renderResultSets(response []byte, rolledBack bool) (string, error) {
+	result := [][]interface{}{}
+	err := json.Unmarshal(response, &result)
+	if err != nil {
+		return "", errors.New(strings.TrimSpace(string(response)))
+	}
+	var buffer bytes.Buffer
+	for i, statements := range result {
+		if len(result) > 1 {
+			buffer.WriteString(fmt.Sprintln("# params", i+1))
+		}
+		for _, statement := range statements {
+			switch v := statement.(type) {
+			case float64:
+				buffer.WriteString(fmt.Sprintln(v, "rows affected."))
+			case []interface{}:
+				buffer.WriteString(renderResultSet(v))
+			default:
+				buffer.WriteString(fmt.Sprintln(v))
+			}
+			buffer.WriteString("\n")
+		}
+	}
+	if rolledBack {
+		buffer.WriteString("Rolled back.\n")
+	}
+	return strings.TrimSpace(buffer.String()), nil
+}
+
+// renderResultSet renders a result set as a table, from a header row and data
+// rows in array mode, or from rows of objects.
+func renderResultSet(rows []interface{}) string {
+	var buffer bytes.Buffer
+	w := tabwriter.NewWriter(&buffer, 0, 4, 2, ' ', 0)
+	count := 0
+	for i, row := range rows {
+		switch v := row.(type) {
+		case []interface{}:
+			values := []string{}
+			for _, value := range v {
+				values = append(values, formatField(value))
+			}
+			fmt.Fprintln(w, strings.Join(values, "\t"))
+			if i > 0 {
+				count++
+			}
+		case map[string]interface{}:
+			keys := []string{}
+			for key := range v {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			if i == 0 {
+				fmt.Fprintln(w, strings.Join(keys, "\t"))
+			}
+			values := []string{}
+			for _, key := range keys {
+				values = append(values, formatField(v[key]))
+			}
+			fmt.Fprintln(w, strings.Join(values, "\t"))
+			count++
+		}
+	}
+	w.Flush()
+	buffer.WriteString(fmt.Sprintf("(%v rows)\n", count))
+	return buffer.String()
+}
+
+// queryExecCommand builds the cli command running a query on the master.
+func queryExecCommand() cli.Command {
+	return cli.Command{
+		Name:  "exec",
+		Usage: "execute a query on the master",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "node, N",
+				Value: "127.0.0.1:2015",
+				Usage: "node url, format: host:port. 127.0.0.1:2015 if empty",
+			},
+			cli.StringFlag{
+				Name:  "app, a",
+				Usage: "app name or id",
+			},
+			cli.StringFlag{
+				Name:  "name, n",
+				Usage: "name or id of the query",
+			},
+			cli.StringFlag{
+				Name:  "params, p",
+				Usage: "json array of parameters, or an array of them to execute the query for each",
+			},
+			cli.StringFlag{
+				Name:  "query-params, q",
+				Usage: "json object of query parameters",
+			},
+			cli.StringFlag{
+				Name:  "case, c",
+				Usage: "case of the column names: lower, upper or camel, as is if empty",
+			},
+			cli.BoolFlag{
+				Name:  "interceptors",
+				Usage: "run the interceptors, which are bypassed by default",
+			},
+			cli.StringFlag{
+				Name:  "token, k",
+				Usage: "api token, for the interceptors",
+			},
+			cli.StringFlag{
+				Name:  "user-token",
+				Usage: "user token, for the interceptors",
+			},
+			cli.BoolFlag{
+				Name:  "rollback",
+				Usage: "roll back the transaction at the end",
+			},
+			cli.StringFlag{
+				Name:  "output, o",
+				Value: "table",
+				Usage: "output format: json or table",
+			},
+			cli.StringFlag{
+				Name:        "secret, z",
+				Usage:       "secret password for server client communication.",
+				Destination: &service.Secret,
+			},
+		},
+		Action: func(c *cli.Context) error {
+			if err := service.LoadSecrets(c); err != nil {
+				fmt.Println(err)
+				return err
+			}
+			node := cliNode(c)
+			queryExec := &QueryExec{
+				Id:           c.String("name"),
+				AppId:        cliApp(c),
+				Case:         c.String("case"),
+				Interceptors: c.Bool("interceptors"),
+				ApiToken:     c.String("token"),
+				UserToken:    c.String("user-token"),
+				Rollback:     c.Bool("rollback"),
+				Array:        c.String("output") != "json",
+			}
+			if c.IsSet("params") {
+				queryExec.Params = json.RawMessage(c.String("params"))
+				if _, err := queryExec.params(); err != nil {
+					fmt.Println(err)
+					return err
+				}
+			}
+			if c.IsSet("query-params") {
+				err := json.Unmarshal([]byte(c.String("query-params")), &queryExec.QueryParams)
+				if err != nil {
+					fmt.Println(err)
+					return err
+				}
+			}
+			queryExecBytes, err := json.Marshal(queryExec)
+			if err != nil {
+				fmt.Println(err)
+				return err
+			}
+			cliQueryExecCommand := &Command{
+				Type: "CLI_QUERY_EXEC",
+				Data: string(queryExecBytes),
+			}
+			response, err := sendCliCommand(node, cliQueryExecCommand, true)
+			if err != nil {
+				fmt.Println(err)
+				return err
+			}
+			var output string
+			if c.String("output") == "json" {
+				output, err = renderOutput("QUERY", "json", response)
+			} else {
+				output, err = renderResultSets(response, queryExec.Rollback)
+			}
+			if err != nil {
+				fmt.Println(err)
+				return err
+			}
+			if output != "" {
+				fmt.Println(output)
+			}
+			return nil
+		},
+	}
+}
@@ -0,0 +1,58 @@
+// query_exec_test
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestQueryExecParams(t *testing.T) {
+	tests := []struct {
+		params  string
+		want    [][]interface{}
+		wantErr bool
+	}{
+		{"", [][]interface{}{{}}, false},
+		{"null", [][]interface{}{{}}, false},
+		{"[]", [][]interface{}{{}}, false},
+		{`[1,"a"]`, [][]interface{}{{float64(1), "a"}}, false},
+		{`[[1],[2,"b"]]`, [][]interface{}{{float64(1)}, {float64(2), "b"}}, false},
+		{`{"id":1}`, nil, true},
+	}
+	for _, test := range tests {
+		queryExec := &QueryExec{Params: []byte(test.params)}
+		params, err := queryExec.params()
+		if (err != nil) != test.wantErr {
+			t.Errorf("params of %q failed with %v, want error %v", test.params, err, test.wantErr)
+			continue
+		}
+		if !test.wantErr && !reflect.DeepEqual(params, test.want) {
+			t.Errorf("params of %q = %v, want %v", test.params, params, test.want)
+		}
+	}
+}
+
+func TestRenderResultSets(t *testing.T) {
+	output, err := renderResultSets([]byte(`[[[["id","name"],[1,"a"],[2,"b"]],3]]`), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "id  name\n1   a\n2   b\n(2 rows)\n\n3 rows affected."
+	if output != want {
+		t.Errorf("array result sets rendered as\n%v\nwant\n%v", output, want)
+	}
+
+	output, err = renderResultSets([]byte(`[[[{"name":"a","id":1}]],[[]]]`), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = "# params 1\nid  name\n1   a\n(1 rows)\n\n# params 2\n(0 rows)\n\nRolled back."
+	if output != want {
+		t.Errorf("object result sets rendered as\n%v\nwant\n%v", output, want)
+	}
+
+	_, err = renderResultSets([]byte("Query not found: q1\n"), false)
+	if err == nil || err.Error() != "Query not found: q1" {
+		t.Errorf("error of the master rendered as %v", err)
+	}
+}
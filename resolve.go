@@ -0,0 +1,195 @@
+// resolve
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// The cli accepts names or ids wherever it references an app, a data node, a
+// query, a job, a token or an interceptor. The master replaces the names with
+// the ids before processing the command. An id always wins over a name, and a
+// name matching more than one entity is refused.
+
+type namedEntity struct {
+	Id   string
+	Name string
+}
+
+var entityNames = map[string]string{
+	"DN":    "data node",
+	"APP":   "app",
+	"QUERY": "query",
+	"JOB":   "job",
+	"TOKEN": "token",
+	"LI":    "local interceptor",
+	"RI":    "remote interceptor",
+}
+
+// resolveName returns the id of the candidate whose id or name is ref.
+func resolveName(entity string, ref string, candidates []namedEntity) (string, error) {
+	matches := []string{}
+	for _, v := range candidates {
+		if v.Id == ref {
+			return v.Id, nil
+		}
+		if v.Name == ref {
+			matches = append(matches, v.Id)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return "", errors.New("No " + entity + " with name or id: " + ref)
+	case 1:
+		return matches[0], nil
+	}
+	return "", errors.New(fmt.Sprint("Ambiguous ", entity, " name: ", ref, " matches ", len(matches), " ", entity, "s, use one of the ids: ", strings.Join(matches, ", ")))
+}
+
+func (this *MasterData) resolveDataNode(ref string) (string, error) {
+	candidates := []namedEntity{}
+	for _, v := range this.DataNodes {
+		candidates = append(candidates, namedEntity{v.Id, v.Name})
+	}
+	return resolveName(entityNames["DN"], ref, candidates)
+}
+
+func (this *MasterData) resolveApp(ref string) (string, error) {
+	candidates := []namedEntity{}
+	for _, v := range this.Apps {
+		candidates = append(candidates, namedEntity{v.Id, v.Name})
+	}
+	return resolveName(entityNames["APP"], ref, candidates)
+}
+
+// resolveChild resolves ref among the entities of kind, e.g. QUERY, of the app
+// with appId.
+func (this *MasterData) resolveChild(kind string, appId string, ref string) (string, error) {
+	candidates := []namedEntity{}
+	for _, app := range this.Apps {
+		if app.Id != appId {
+			continue
+		}
+		switch kind {
+		case "QUERY":
+			for _, v := range app.Queries {
+				candidates = append(candidates, namedEntity{v.Id, v.Name})
+			}
+		case "JOB":
+			for _, v := range app.Jobs {
+				candidates = append(candidates, namedEntity{v.Id, v.Name})
+			}
+		case "TOKEN":
+			for _, v := range app.Tokens {
+				candidates = append(candidates, namedEntity{v.Id, v.Name})
+			}
+		case "LI":
+			for _, v := range app.LocalInterceptors {
+				candidates = append(candidates, namedEntity{v.Id, v.Name})
+			}
+		case "RI":
+			for _, v := range app.RemoteInterceptors {
+				candidates = append(candidates, namedEntity{v.Id, v.Name})
+			}
+		}
+	}
+	return resolveName(entityNames[kind], ref, candidates)
+}
+
+// resolveRefs replaces the names referenced by a cli command with ids, in
+// place.
+func resolveRefs(cliCommand *Command) error {
+	parts := strings.SplitN(strings.TrimPrefix(cliCommand.Type, "CLI_"), "_", 2)
+	if len(parts) != 2 {
+		return nil
+	}
+	kind, op := parts[0], parts[1]
+
+	masterDataMutex.Lock()
+	defer masterDataMutex.Unlock()
+
+	// Commands carrying a bare reference.
+	switch cliCommand.Type {
+	case "CLI_DN_REMOVE":
+		id, err := masterData.resolveDataNode(cliCommand.Data)
+		if err != nil {
+			return err
+		}
+		cliCommand.Data = id
+		return nil
+	case "CLI_APP_REMOVE", "CLI_QUERY_RELOAD_ALL", "CLI_SHOW_STATS":
+		if cliCommand.Data == "" {
+			return nil
+		}
+		id, err := masterData.resolveApp(cliCommand.Data)
+		if err != nil {
+			return err
+		}
+		cliCommand.Data = id
+		return nil
+	}
+
+	if _, ok := entityNames[kind]; !ok {
+		return nil
+	}
+	fields := map[string]interface{}{}
+	err := json.Unmarshal([]byte(cliCommand.Data), &fields)
+	if err != nil {
+		return err
+	}
+	resolve := func(field string, f func(ref string) (string, error)) error {
+		ref, ok := fields[field].(string)
+		if !ok || ref == "" {
+			return nil
+		}
+		id, err := f(ref)
+		if err != nil {
+			return err
+		}
+		fields[field] = id
+		return nil
+	}
+	switch {
+	case op == "LIST":
+		err = resolve("AppId", masterData.resolveApp)
+	case kind == "DN":
+		if op != "ADD" {
+			err = resolve("Id", masterData.resolveDataNode)
+		}
+	case kind == "APP":
+		if op != "ADD" {
+			err = resolve("Id", masterData.resolveApp)
+		}
+		if err == nil {
+			err = resolve("DataNodeId", masterData.resolveDataNode)
+		}
+	default:
+		ref, _ := fields["AppId"].(string)
+		err = resolve("AppId", masterData.resolveApp)
+		if err == nil && kind == "TOKEN" && op == "ADD" {
+			// Token ids start with the id of their app.
+			if id, ok := fields["Id"].(string); ok && ref != "" && strings.HasPrefix(id, ref) {
+				fields["Id"] = fields["AppId"].(string) + strings.TrimPrefix(id, ref)
+			}
+		}
+		if err == nil && op != "ADD" {
+			appId, _ := fields["AppId"].(string)
+			if appId != "" {
+				err = resolve("Id", func(ref string) (string, error) {
+					return masterData.resolveChild(kind, appId, ref)
+				})
+			}
+		}
+	}
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return err
+	}
+	cliCommand.Data = string(data)
+	return nil
+}
@@ -0,0 +1,35 @@
+// resolve_test
+package main
+
+import (
+	"testing"
+)
+
+func TestResolveName(t *testing.T) {
+	candidates := []namedEntity{
+		{"id1", "users"},
+		{"id2", "orders"},
+		{"id3", "orders"},
+		{"id4", "id1"},
+	}
+	if id, err := resolveName("app", "id2", candidates); err != nil || id != "id2" {
+		t.Errorf("resolved id2 to %q, %v", id, err)
+	}
+	if id, err := resolveName("app", "users", candidates); err != nil || id != "id1" {
+		t.Errorf("resolved users to %q, %v", id, err)
+	}
+	// id4 is named like the id of id1, the id wins.
+	if id, err := resolveName("app", "id1", candidates); err != nil || id != "id1" {
+		t.Errorf("resolved id1 to %q, %v", id, err)
+	}
+
+	_, err := resolveName("app", "orders", candidates)
+	want := "Ambiguous app name: orders matches 2 apps, use one of the ids: id2, id3"
+	if err == nil || err.Error() != want {
+		t.Errorf("resolving an ambiguous name failed with %v, want %q", err, want)
+	}
+	_, err = resolveName("app", "items", candidates)
+	if err == nil || err.Error() != "No app with name or id: items" {
+		t.Errorf("resolving an unknown name failed with %v", err)
+	}
+}
@@ -16,11 +16,19 @@ func serve(service *CliService) {
 		w.Header().Set("Access-Control-Allow-Methods", r.Header.Get("Access-Control-Request-Method"))
 		w.Header().Set("Access-Control-Allow-Headers", r.Header.Get("Access-Control-Request-Headers"))
 
+		if isStale() {
+			w.Header().Set("X-Netdata-Stale", fmt.Sprint(getMasterIndex().Version))
+		}
+
 		if r.Method == "OPTIONS" {
 			return
 		}
 
 		urlPath := r.URL.Path
+		if isDraining() && strings.HasPrefix(urlPath, "/api/") {
+			http.Error(w, "Draining.", http.StatusServiceUnavailable)
+			return
+		}
 		var dataHandler func(w http.ResponseWriter, r *http.Request)
 		if strings.HasPrefix(urlPath, "/api/") {
 			dataHandler = gorest2.GetHandler("/api")
@@ -77,7 +85,16 @@ func serve(service *CliService) {
 	if service.EnableHttps {
 		go func() {
 			fmt.Println(fmt.Sprint("Listening on https://", service.HttpsHost, ":", service.HttpsPort, "/"))
-			err := http.ListenAndServeTLS(fmt.Sprint(service.HttpsHost, ":", service.HttpsPort), service.CertFile, service.KeyFile, nil)
+			tlsConfig, err := serverTLSConfig()
+			if err != nil {
+				fmt.Println(err)
+				return
+			}
+			server := &http.Server{
+				Addr:      fmt.Sprint(service.HttpsHost, ":", service.HttpsPort),
+				TLSConfig: tlsConfig,
+			}
+			err = server.ListenAndServeTLS(service.CertFile, service.KeyFile)
 			if err != nil {
 				fmt.Println(err)
 			}
@@ -0,0 +1,67 @@
+// slave_cache
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"sync/atomic"
+)
+
+// Slaves keep the last master data received from the master in a cache file,
+// so that they can boot and serve /api while the master is unreachable. Until
+// the master sends fresh data, the slave reports that it runs stale.
+
+var slaveStale int32
+
+func setStale(stale bool) {
+	if stale {
+		atomic.StoreInt32(&slaveStale, 1)
+	} else {
+		atomic.StoreInt32(&slaveStale, 0)
+	}
+}
+
+func isStale() bool {
+	return atomic.LoadInt32(&slaveStale) == 1
+}
+
+func loadSlaveCache() error {
+	masterDataBytes, err := ioutil.ReadFile(service.CacheFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	cached := MasterData{}
+	err = json.Unmarshal(masterDataBytes, &cached)
+	if err != nil {
+		return err
+	}
+	masterDataMutex.Lock()
+	masterData = cached
+	masterData.reindex()
+	masterDataMutex.Unlock()
+	log.Println("Loaded cached master data version", cached.Version, "from", service.CacheFile)
+	return nil
+}
+
+func saveSlaveCache() {
+	if strings.TrimSpace(service.Master) == "" || strings.TrimSpace(service.CacheFile) == "" {
+		return
+	}
+	masterDataMutex.Lock()
+	masterDataBytes, err := json.Marshal(masterData)
+	masterDataMutex.Unlock()
+	if err != nil {
+		log.Println("Failed to cache master data:", err)
+		return
+	}
+	err = writeFileAtomic(service.CacheFile, masterDataBytes, 0600)
+	if err != nil {
+		log.Println("Failed to cache master data:", err)
+	}
+}
@@ -0,0 +1,46 @@
+// slave_cache_test
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestSlaveCache(t *testing.T) {
+	saved, master, cacheFile := masterData, service.Master, service.CacheFile
+	defer func() {
+		masterData, service.Master, service.CacheFile = saved, master, cacheFile
+	}()
+	service.Master = "master.local:8080"
+	service.CacheFile = filepath.Join(t.TempDir(), "netdata_cache.json")
+
+	// Nothing cached yet, the slave boots empty.
+	masterData = MasterData{Version: 3}
+	err := loadSlaveCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if masterData.Version != 3 {
+		t.Fatalf("at version %v after loading a missing cache, want 3", masterData.Version)
+	}
+
+	masterData = MasterData{Version: 5, DataNodes: []*DataNode{{Id: "dn1", Name: "dn1", Host: "db.local"}}}
+	saveSlaveCache()
+	masterData = MasterData{}
+	err = loadSlaveCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if masterData.Version != 5 || len(masterData.DataNodes) != 1 || masterData.DataNodes[0].Host != "db.local" {
+		t.Errorf("loaded version %v, data nodes %+v", masterData.Version, masterData.DataNodes)
+	}
+
+	err = ioutil.WriteFile(service.CacheFile, []byte(`{"Version":`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loadSlaveCache() == nil {
+		t.Error("corrupt cache loaded")
+	}
+}
@@ -0,0 +1,173 @@
+// slave_conn
+package main
+
+import (
+	"errors"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// SlaveConn is the master side of the web socket of a slave. Messages to the
+// slave are queued, and written by a goroutine of its own, so that a slow
+// slave does not hold up the others. A slave whose queue fills up, or that
+// does not take a message within WriteTimeout, is evicted.
+type SlaveConn struct {
+	Id        string
+	conn      *websocket.Conn
+	queue     chan interface{}
+	closed    chan bool
+	closeOnce *sync.Once
+}
+
+func NewSlaveConn(conn *websocket.Conn) *SlaveConn {
+	queueSize := service.SendQueueSize
+	if queueSize <= 0 {
+		queueSize = 1
+	}
+	this := &SlaveConn{
+		conn:      conn,
+		queue:     make(chan interface{}, queueSize),
+		closed:    make(chan bool),
+		closeOnce: &sync.Once{},
+	}
+	go this.write()
+	return this
+}
+
+func (this *SlaveConn) write() {
+	writeTimeout := time.Duration(service.WriteTimeout) * time.Second
+	for {
+		select {
+		case <-this.closed:
+			return
+		case message := <-this.queue:
+			if message == closeAfterWrite {
+				this.Close()
+				return
+			}
+			if writeTimeout > 0 {
+				this.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+			}
+			err := this.conn.WriteJSON(message)
+			if err != nil {
+				log.Println(this.RemoteAddr(), "evicted, failed to write:", err)
+				this.Close()
+				return
+			}
+		}
+	}
+}
+
+func (this *SlaveConn) RemoteAddr() net.Addr {
+	return this.conn.RemoteAddr()
+}
+
+// Send queues a message for the slave, without waiting for it to be written.
+func (this *SlaveConn) Send(message interface{}) error {
+	if this.isClosed() {
+		return errors.New("Connection closed: " + this.RemoteAddr().String())
+	}
+	select {
+	case this.queue <- message:
+		return nil
+	default:
+		log.Println(this.RemoteAddr(), "evicted, send queue full.")
+		this.Close()
+		return errors.New("Slave too slow, evicted: " + this.RemoteAddr().String())
+	}
+}
+
+func (this *SlaveConn) isClosed() bool {
+	select {
+	case <-this.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+// closeAfterWrite is queued after a last message, to close the connection
+// once the writer got to it.
+var closeAfterWrite = &struct{}{}
+
+// SendAndClose queues a last message for the slave, and closes the connection
+// once it is written. It waits for room in the queue for the close, rather
+// than evicting the slave before its last message.
+func (this *SlaveConn) SendAndClose(message interface{}) {
+	if this.Send(message) != nil {
+		return
+	}
+	select {
+	case this.queue <- closeAfterWrite:
+	case <-this.closed:
+	}
+}
+
+// Close stops the writer and closes the connection, which ends the reading
+// goroutine of serveWs and the registration of the slave.
+func (this *SlaveConn) Close() {
+	this.closeOnce.Do(func() {
+		close(this.closed)
+		this.conn.Close()
+	})
+}
+
+// SlaveRegistry holds the connections of the registered slaves by id.
+type SlaveRegistry struct {
+	mutex *sync.RWMutex
+	conns map[string]*SlaveConn
+}
+
+func NewSlaveRegistry() *SlaveRegistry {
+	return &SlaveRegistry{
+		mutex: &sync.RWMutex{},
+		conns: map[string]*SlaveConn{},
+	}
+}
+
+// Add registers a slave connection. The id of a slave stays taken while its
+// connection is open, so that another node claiming it is refused instead of
+// evicting it. A slave reconnecting after a network failure is refused too,
+// until the master drops the old connection for want of pongs, and retries.
+func (this *SlaveRegistry) Add(conn *SlaveConn) error {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	previous := this.conns[conn.Id]
+	if previous != nil && previous != conn && !previous.isClosed() {
+		return errors.New("Slave already registered: " + conn.Id + ", connected from " + previous.RemoteAddr().String())
+	}
+	this.conns[conn.Id] = conn
+	return nil
+}
+
+func (this *SlaveRegistry) Remove(conn *SlaveConn) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	if this.conns[conn.Id] == conn {
+		delete(this.conns, conn.Id)
+	}
+}
+
+func (this *SlaveRegistry) Get(id string) *SlaveConn {
+	this.mutex.RLock()
+	defer this.mutex.RUnlock()
+	return this.conns[id]
+}
+
+func (this *SlaveRegistry) Registered(conn *SlaveConn) bool {
+	return conn.Id != "" && this.Get(conn.Id) == conn
+}
+
+func (this *SlaveRegistry) All() []*SlaveConn {
+	this.mutex.RLock()
+	defer this.mutex.RUnlock()
+	all := make([]*SlaveConn, 0, len(this.conns))
+	for _, v := range this.conns {
+		all = append(all, v)
+	}
+	return all
+}
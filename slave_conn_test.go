@@ -0,0 +1,91 @@
+// slave_conn_test
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSlaveRegistryDuplicateId(t *testing.T) {
+	_, first := testWsPair(t)
+	_, second := testWsPair(t)
+	registry := NewSlaveRegistry()
+	conn := NewSlaveConn(first)
+	conn.Id = "slave1"
+	other := NewSlaveConn(second)
+	other.Id = "slave1"
+	defer conn.Close()
+	defer other.Close()
+
+	err := registry.Add(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if registry.Add(other) == nil {
+		t.Fatal("second connection registered with the id of an open one")
+	}
+	if registry.Get("slave1") != conn || other.isClosed() || conn.isClosed() {
+		t.Fatal("refused registration evicted the registered connection, or closed a connection")
+	}
+
+	// Once the registered connection is closed, its slave may come back.
+	conn.Close()
+	err = registry.Add(other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !registry.Registered(other) || registry.Registered(conn) {
+		t.Error("closed connection not replaced")
+	}
+	registry.Remove(conn)
+	if registry.Get("slave1") != other {
+		t.Error("removing the replaced connection unregistered its successor")
+	}
+}
+
+func TestSlaveConnQueueOverflow(t *testing.T) {
+	client, server := testWsPair(t)
+	// Without its writer, nothing drains the queue.
+	conn := &SlaveConn{
+		conn:      server,
+		queue:     make(chan interface{}, 1),
+		closed:    make(chan bool),
+		closeOnce: &sync.Once{},
+	}
+	err := conn.Send("first")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conn.Send("second") == nil {
+		t.Fatal("message queued beyond the queue size")
+	}
+	if !conn.isClosed() {
+		t.Fatal("slow slave not evicted")
+	}
+	if conn.Send("third") == nil {
+		t.Error("message queued for an evicted slave")
+	}
+	client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, _, err := client.ReadMessage(); err == nil {
+		t.Error("evicted slave still connected")
+	}
+}
+
+func TestSlaveConnSendAndClose(t *testing.T) {
+	client, server := testWsPair(t)
+	conn := NewSlaveConn(server)
+	conn.SendAndClose("Failed to validate client secret.")
+	client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var message string
+	err := client.ReadJSON(&message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if message != "Failed to validate client secret." {
+		t.Errorf("last message %q", message)
+	}
+	if _, _, err := client.ReadMessage(); err == nil {
+		t.Error("connection left open after the last message")
+	}
+}
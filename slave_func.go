@@ -2,19 +2,52 @@
 package main
 
 import (
-	"crypto/tls"
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
 )
 
+// cliTimeout bounds a cli command sent to a node, long enough for imports and
+// query execs.
+var cliTimeout = 5 * time.Minute
+
+var cliClientOnce = &sync.Once{}
+var cliClient *http.Client
+var cliClientErr error
+
+// getCliClient returns the client shared by all cli commands, so that they
+// reuse its connections.
+func getCliClient() (*http.Client, error) {
+	cliClientOnce.Do(func() {
+		tlsConfig, err := clientTLSConfig(true)
+		if err != nil {
+			cliClientErr = err
+			return
+		}
+		cliClient = &http.Client{
+			Transport: &http.Transport{TLSClientConfig: tlsConfig},
+			Timeout:   cliTimeout,
+		}
+	})
+	return cliClient, cliClientErr
+}
+
 func sendCliCommand(node string, command *Command, attachSecret bool) ([]byte, error) {
+	return sendCliCommandWithin(node, command, attachSecret, cliTimeout)
+}
+
+// sendCliCommandWithin sends a cli command to a node, and gives up on the
+// response after timeout.
+func sendCliCommandWithin(node string, command *Command, attachSecret bool, timeout time.Duration) ([]byte, error) {
 	if attachSecret {
 		command.Secret = service.Secret
 	}
@@ -22,73 +55,137 @@ func sendCliCommand(node string, command *Command, attachSecret bool) ([]byte, e
 	if err != nil {
 		return nil, err
 	}
-	tr := &http.Transport{
-		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-	}
-	client := &http.Client{Transport: tr}
-	req, err := http.NewRequest("POST", "https://"+node+"/sys/cli", strings.NewReader(string(message)))
+	client, err := getCliClient()
 	if err != nil {
 		return nil, err
 	}
-
-	res, err := client.Do(req)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "POST", "https://"+node+"/sys/cli", strings.NewReader(string(message)))
 	if err != nil {
 		return nil, err
 	}
-	result, err := ioutil.ReadAll(res.Body)
+
+	res, err := client.Do(req)
 	if err != nil {
 		return nil, err
 	}
-	return result, err
+	defer res.Body.Close()
+	return ioutil.ReadAll(res.Body)
 }
 
+var masterNodeIndex int
+var reconnectBackoff = NewBackoff(time.Second, time.Minute)
+
+// RegisterToMaster registers to the first master node that accepts, starting
+// with the one registered to last.
 func RegisterToMaster(wsDrop chan bool) error {
-	c, _, err := websocket.DefaultDialer.Dial("wss://"+service.Master+"/sys/ws", nil)
+	if isMaster() {
+		return nil
+	}
+	nodes := masterNodes()
+	var err error
+	for i := 0; i < len(nodes); i++ {
+		node := nodes[(masterNodeIndex+i)%len(nodes)]
+		err = registerToMasterNode(node, wsDrop)
+		if err == nil {
+			masterNodeIndex = (masterNodeIndex + i) % len(nodes)
+			reconnectBackoff.Reset()
+			return nil
+		}
+		log.Println(node, err)
+	}
+	delay := reconnectBackoff.Next()
+	if isStale() {
+		log.Println("No master reachable, serving stale master data version", masterData.Version)
+	}
+	log.Println("Retrying in", delay)
+	// The caller handles signals too, so the retry is scheduled rather than
+	// waited for.
+	time.AfterFunc(delay, func() { wsDrop <- true })
+	if err == nil {
+		err = errors.New("No master node.")
+	}
+	return err
+}
+
+// registerTimeout bounds each step of the registration handshake with a master.
+var registerTimeout = 45 * time.Second
+
+func registerToMasterNode(node string, wsDrop chan bool) error {
+	tlsConfig, err := clientTLSConfig(true)
+	if err != nil {
+		return err
+	}
+	dialer := &websocket.Dialer{
+		Proxy:            http.ProxyFromEnvironment,
+		HandshakeTimeout: registerTimeout,
+		TLSClientConfig:  tlsConfig,
+	}
+	c, _, err := dialer.Dial("wss://"+node+"/sys/ws", nil)
 	if err != nil {
-		log.Println(err)
-		time.Sleep(time.Second * 5)
-		wsDrop <- true
 		return err
 	}
 
-	serviceBytes, err := json.Marshal(service)
+	challenge := &Command{}
+	c.SetReadDeadline(time.Now().Add(registerTimeout))
+	err = c.ReadJSON(challenge)
+	if err != nil {
+		c.Close()
+		return err
+	}
+	if challenge.Type != "WS_CHALLENGE" {
+		c.Close()
+		return errors.New("Expected a challenge from master, got: " + challenge.Type)
+	}
+	registrationBytes, err := json.Marshal(NewSlaveRegistration(challenge.Data))
 	if err != nil {
-		log.Println(err)
-		time.Sleep(time.Second * 5)
-		wsDrop <- true
+		c.Close()
 		return err
 	}
 	regCommand := Command{
 		Type: "WS_REGISTER",
-		Data: string(serviceBytes),
+		Data: string(registrationBytes),
 	}
 
 	// Register
 	if err := c.WriteJSON(regCommand); err != nil {
-		log.Println(err)
-		time.Sleep(time.Second * 5)
-		wsDrop <- true
+		c.Close()
 		return err
 	}
 	var regResult string
-	c.ReadJSON(&regResult)
+	c.SetReadDeadline(time.Now().Add(registerTimeout))
+	err = c.ReadJSON(&regResult)
+	if err != nil {
+		c.Close()
+		return err
+	}
 	if regResult != "OK" {
-		log.Println(regResult)
+		c.Close()
 		return errors.New(regResult)
 	}
+	c.SetReadDeadline(time.Time{})
 
 	slaveConn = c
+	currentMaster.Store(node)
+	done := make(chan bool)
+	keepAlive(c, done)
 	go func() {
 		defer c.Close()
 		defer func() { wsDrop <- true }()
+		defer close(done)
 		for {
 			_, message, err := c.ReadMessage()
 			if err != nil {
-				log.Println("Connection dropped. Reconnecting in 5 seconds...", err)
-				time.Sleep(time.Second * 5)
+				currentMaster.Store("")
+				setStale(true)
+				delay := reconnectBackoff.Next()
+				log.Println("Connection dropped. Reconnecting in", delay, err)
+				time.Sleep(delay)
 				// Reconnect
 				return
 			}
+			extendReadDeadline(c)
 			err = processWsCommandSlave(c, message)
 			if err != nil {
 				log.Println(err)
@@ -96,13 +193,16 @@ func RegisterToMaster(wsDrop chan bool) error {
 		}
 	}()
 
-	log.Println("Connected to master:", service.Master)
+	log.Println("Connected to master:", node)
 	return nil
 }
 
 func processWsCommandSlave(conn *websocket.Conn, message []byte) error {
 	wsCommand := &Command{}
-	json.Unmarshal(message, wsCommand)
+	err := json.Unmarshal(message, wsCommand)
+	if err != nil {
+		return requestResync(conn, err)
+	}
 	switch wsCommand.Type {
 	case "WS_MASTER_DATA":
 		masterCommand := &Command{}
@@ -110,7 +210,111 @@ func processWsCommandSlave(conn *websocket.Conn, message []byte) error {
 		if err != nil {
 			return err
 		}
-		return json.Unmarshal([]byte(masterCommand.Data), &masterData)
+		newMasterData := MasterData{}
+		err = json.Unmarshal([]byte(masterCommand.Data), &newMasterData)
+		if err != nil {
+			ackMasterData(conn, err)
+			return err
+		}
+		masterDataMutex.Lock()
+		masterData = newMasterData
+		masterData.reindex()
+		masterDataMutex.Unlock()
+		forgetAllDbos()
+		if isStale() {
+			log.Println("Received master data version", masterData.Version, "no longer stale.")
+		}
+		setStale(false)
+		saveSlaveCache()
+		return ackMasterData(conn, nil)
+	case "WS_OP":
+		return runSlaveOp(conn, wsCommand.Data)
+	case "WS_LEASE":
+		renewLease()
+		if strings.TrimSpace(service.Master) == "" {
+			// The standby acknowledges the lease, which the primary needs to
+			// keep accepting changes.
+			return writeToMaster(conn, &Command{
+				Type: "WS_LEASE_ACK",
+				Data: service.Id,
+				Meta: wsCommand.Meta,
+			})
+		}
+	case "WS_MASTER_PATCH":
+		entry := &JournalEntry{}
+		err := json.Unmarshal([]byte(wsCommand.Data), entry)
+		if err != nil {
+			return requestResync(conn, err)
+		}
+		if entry.Version <= masterData.Version {
+			// Already applied.
+			return nil
+		}
+		if entry.Version != masterData.Version+1 {
+			return requestResync(conn, errors.New(fmt.Sprint("Missed master data versions ", masterData.Version+1, " to ", entry.Version-1, ".")))
+		}
+		// Apply to a copy, so that a failed patch leaves the master data as is.
+		patched := &MasterData{}
+		err = deepCopy(&masterData, patched)
+		if err != nil {
+			return requestResync(conn, err)
+		}
+		err = patched.ApplyChanges(entry.Changes)
+		if err != nil {
+			return requestResync(conn, err)
+		}
+		patched.Version = entry.Version
+		masterDataMutex.Lock()
+		masterData = *patched
+		masterData.reindex()
+		masterDataMutex.Unlock()
+		forgetDbos(entry.Changes)
+		saveSlaveCache()
+		return ackMasterData(conn, nil)
 	}
 	return nil
 }
+
+// requestResync asks the master for a full snapshot of the master data, when
+// a patch cannot be applied.
+func requestResync(conn *websocket.Conn, cause error) error {
+	log.Println(cause, "Requesting resync from master.")
+	ackMasterData(conn, cause)
+	return writeToMaster(conn, &Command{
+		Type: "WS_RESYNC",
+		Data: fmt.Sprint(masterData.Version),
+	})
+}
+
+// MasterDataAck reports the version of the master data a slave has applied,
+// and why it failed to apply a newer one, if it did.
+type MasterDataAck struct {
+	Version int64
+	Error   string
+}
+
+func ackMasterData(conn *websocket.Conn, cause error) error {
+	ack := &MasterDataAck{
+		Version: masterData.Version,
+	}
+	if cause != nil {
+		ack.Error = cause.Error()
+	}
+	ackBytes, err := json.Marshal(ack)
+	if err != nil {
+		return err
+	}
+	return writeToMaster(conn, &Command{
+		Type: "WS_ACK",
+		Data: string(ackBytes),
+	})
+}
+
+var slaveWriteMutex = &sync.Mutex{}
+
+// writeToMaster serializes the writes of the slave to the master connection.
+func writeToMaster(conn *websocket.Conn, command *Command) error {
+	slaveWriteMutex.Lock()
+	defer slaveWriteMutex.Unlock()
+	return conn.WriteJSON(command)
+}
@@ -0,0 +1,103 @@
+// slave_func_test
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// testWsPair returns both ends of a web socket, the dialing end first.
+func testWsPair(t *testing.T) (*websocket.Conn, *websocket.Conn) {
+	accepted := make(chan *websocket.Conn, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		accepted <- conn
+	}))
+	t.Cleanup(server.Close)
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	serverConn := <-accepted
+	t.Cleanup(func() { serverConn.Close() })
+	return client, serverConn
+}
+
+func patchMessage(t *testing.T, version int64, dataNode *DataNode) []byte {
+	change, err := NewMasterDataChange("DataNode", "add", dataNode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entryBytes, err := json.Marshal(&JournalEntry{Version: version, Changes: []*MasterDataChange{change}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	message, err := json.Marshal(&Command{Type: "WS_MASTER_PATCH", Data: string(entryBytes)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return message
+}
+
+// readResync reads what the slave wrote to the master up to a WS_RESYNC, and
+// returns the version it resyncs from.
+func readResync(t *testing.T, conn *websocket.Conn) string {
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		command := &Command{}
+		err := conn.ReadJSON(command)
+		if err != nil {
+			t.Fatal("no resync requested:", err)
+		}
+		if command.Type == "WS_RESYNC" {
+			return command.Data
+		}
+	}
+}
+
+func TestSlavePatchGap(t *testing.T) {
+	saved := masterData
+	defer func() { masterData = saved }()
+	masterData = MasterData{Version: 1}
+	slave, master := testWsPair(t)
+
+	err := processWsCommandSlave(slave, patchMessage(t, 2, &DataNode{Id: "dn2", Name: "dn2"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if masterData.Version != 2 || len(masterData.DataNodes) != 1 {
+		t.Fatalf("at version %v with %v data nodes after the next patch, want version 2 with 1", masterData.Version, len(masterData.DataNodes))
+	}
+
+	// A patch already applied is ignored.
+	err = processWsCommandSlave(slave, patchMessage(t, 2, &DataNode{Id: "dn3", Name: "dn3"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(masterData.DataNodes) != 1 {
+		t.Fatalf("%v data nodes after a repeated patch, want 1", len(masterData.DataNodes))
+	}
+
+	// Versions 3 and 4 are missing.
+	err = processWsCommandSlave(slave, patchMessage(t, 5, &DataNode{Id: "dn5", Name: "dn5"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if masterData.Version != 2 || len(masterData.DataNodes) != 1 {
+		t.Errorf("at version %v with %v data nodes after a gap, want it left at version 2 with 1", masterData.Version, len(masterData.DataNodes))
+	}
+	if from := readResync(t, master); from != "2" {
+		t.Errorf("resync requested from version %v, want 2", from)
+	}
+}
@@ -0,0 +1,309 @@
+// standby
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// A primary and a standby master are started with each other as peer. The
+// standby follows the master data of the primary over /sys/ws like a slave,
+// and promotes itself when the primary stops renewing its lease. Slaves list
+// both masters and register to whichever one is active.
+//
+// The standby acknowledges every lease. Once acknowledged, the primary fences
+// itself when the acknowledgements stop for half a lease timeout, before the
+// standby can promote itself: it refuses changes and stops its jobs, until the
+// standby acknowledges again, or steps down once the standby has promoted.
+//
+// A primary cannot tell a dead standby from one it is partitioned from. So
+// that a dead standby does not fence a healthy primary forever, the primary
+// unfences once the standby has been unreachable for a lease timeout. If the
+// standby is in fact alive on the other side of a partition, it promotes
+// itself in the meantime and both nodes accept changes, until the partition
+// heals and the primary steps down, losing the changes it accepted meanwhile.
+// Availability is preferred over a strict
+// single master here; slaves follow whichever master they registered to.
+
+var activeMaster int32
+
+func isMaster() bool {
+	return atomic.LoadInt32(&activeMaster) == 1
+}
+
+// masterNodes returns the nodes a slave, or a standby, registers to.
+func masterNodes() []string {
+	nodes := []string{}
+	masters := service.Master
+	if strings.TrimSpace(masters) == "" {
+		masters = service.Peer
+	}
+	for _, node := range strings.Split(masters, ",") {
+		if strings.TrimSpace(node) != "" {
+			nodes = append(nodes, strings.TrimSpace(node))
+		}
+	}
+	return nodes
+}
+
+var currentMaster atomic.Value
+
+// getCurrentMaster returns the master this node is registered to, empty if
+// none.
+func getCurrentMaster() string {
+	node, _ := currentMaster.Load().(string)
+	return node
+}
+
+// peerTimeout bounds a probe of the role of the peer, which runs every second
+// while fenced.
+var peerTimeout = 2 * time.Second
+
+// peerRole asks the peer for its role, failing when the peer cannot be
+// reached within peerTimeout.
+func peerRole() (string, error) {
+	response, err := sendCliCommandWithin(service.Peer, &Command{Type: "CLI_SHOW_ROLE"}, true, peerTimeout)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(response)), nil
+}
+
+// peerIsMaster tells whether the peer is the active master, in which case a
+// restarted primary joins as standby instead of competing with it.
+func peerIsMaster() bool {
+	if strings.TrimSpace(service.Peer) == "" {
+		return false
+	}
+	peer, err := peerRole()
+	return err == nil && peer == "master"
+}
+
+func role() string {
+	if isMaster() {
+		return "master"
+	}
+	if strings.TrimSpace(service.Master) == "" {
+		return "standby"
+	}
+	if isStale() {
+		return fmt.Sprint("slave, stale at master data version ", getMasterIndex().Version)
+	}
+	return "slave"
+}
+
+var leaseMutex = &sync.Mutex{}
+
+// leaseRenewedAt is when the standby last received the lease, zero until the
+// first one.
+var leaseRenewedAt time.Time
+
+// leaseAckedAt is when the primary sent the last lease the standby
+// acknowledged, zero until the first acknowledgement.
+var leaseAckedAt time.Time
+
+func renewLease() {
+	leaseMutex.Lock()
+	leaseRenewedAt = time.Now()
+	leaseMutex.Unlock()
+}
+
+// leaseExpired tells whether the master stopped renewing its lease. A standby
+// that never received a lease has no master data to promote with, and waits.
+func leaseExpired() bool {
+	leaseMutex.Lock()
+	defer leaseMutex.Unlock()
+	return !leaseRenewedAt.IsZero() && time.Since(leaseRenewedAt) > time.Duration(service.LeaseTimeout)*time.Second
+}
+
+func ackLease(sentAt time.Time) {
+	leaseMutex.Lock()
+	if sentAt.After(leaseAckedAt) {
+		leaseAckedAt = sentAt
+	}
+	leaseMutex.Unlock()
+}
+
+// leaseHeld tells whether the standby still acknowledges the lease of this
+// master, as far as this master knows: the standby cannot promote before a
+// lease timeout since the last lease it acknowledged was sent.
+func leaseHeld() bool {
+	leaseMutex.Lock()
+	defer leaseMutex.Unlock()
+	return leaseAckedAt.IsZero() || time.Since(leaseAckedAt) < time.Duration(service.LeaseTimeout)*time.Second/2
+}
+
+var fenced int32
+
+func isFenced() bool {
+	return atomic.LoadInt32(&fenced) == 1
+}
+
+// SendLeases renews the lease of the master, three times per lease timeout.
+func SendLeases() {
+	interval := time.Duration(service.LeaseTimeout) * time.Second / 3
+	if interval <= 0 {
+		interval = time.Second
+	}
+	for range time.Tick(interval) {
+		if !isMaster() {
+			return
+		}
+		err := broadcast(&Command{
+			Type: "WS_LEASE",
+			Data: service.Id,
+			Meta: map[string]interface{}{"sent_at": time.Now().UnixNano()},
+		})
+		if err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+// WatchFence fences the master while the standby does not acknowledge its
+// lease, and steps down once the standby has promoted itself. A standby that
+// cannot be reached for a lease timeout is taken for dead, and the master
+// unfences.
+func WatchFence() {
+	leaseTimeout := time.Duration(service.LeaseTimeout) * time.Second
+	fencedJobs := []string{}
+	var unreachableSince time.Time
+	fence := func(reason string) {
+		if atomic.CompareAndSwapInt32(&fenced, 0, 1) {
+			log.Println(reason, "Refusing changes and stopping jobs.")
+			fencedJobs = stopAllJobs()
+		}
+	}
+	unfence := func(reason string) {
+		if atomic.CompareAndSwapInt32(&fenced, 1, 0) {
+			log.Println(reason, "Accepting changes.")
+			startJobsById(fencedJobs)
+			fencedJobs = []string{}
+		}
+	}
+	for range time.Tick(time.Second) {
+		if leaseHeld() {
+			unreachableSince = time.Time{}
+			unfence("Standby acknowledges the lease again.")
+			continue
+		}
+		peer, err := peerRole()
+		if err == nil && peer == "master" {
+			log.Println("Standby promoted itself, stepping down. Restart this node to rejoin as standby.")
+			atomic.StoreInt32(&activeMaster, 0)
+			for _, conn := range slaveConns.All() {
+				conn.Close()
+			}
+			return
+		}
+		if err == nil {
+			unreachableSince = time.Time{}
+			fence("Standby stopped acknowledging the lease.")
+			continue
+		}
+		if unreachableSince.IsZero() {
+			unreachableSince = time.Now()
+		}
+		if time.Since(unreachableSince) < leaseTimeout {
+			fence("Standby stopped acknowledging the lease and cannot be reached.")
+		} else {
+			unfence(fmt.Sprint("Standby unreachable for ", leaseTimeout, ", taking it for dead."))
+		}
+	}
+}
+
+// WatchLease promotes the standby once the lease of the master expires.
+func WatchLease() {
+	for range time.Tick(time.Second) {
+		if isMaster() {
+			return
+		}
+		if leaseExpired() {
+			log.Println("Master lease expired, promoting to master.")
+			err := promote()
+			if err != nil {
+				log.Println("Failed to promote to master:", err)
+			}
+			return
+		}
+	}
+}
+
+var promoteOnce = &sync.Once{}
+
+func promote() error {
+	var err error
+	promoteOnce.Do(func() {
+		if conn := slaveConn; conn != nil {
+			conn.Close()
+		}
+		err = startMaster(true)
+	})
+	return err
+}
+
+// startMaster makes this node the active master. A promoted standby starts
+// from the master data it followed, and drops the journal left from an
+// earlier term of this node as master.
+func startMaster(promoted bool) error {
+	var err error
+	commandType := "SERVICE_START"
+	if promoted {
+		commandType = "PROMOTE"
+		masterDataMutex.Lock()
+		err = masterStore.Save(&masterData)
+		masterDataMutex.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+	masterJournal, err = OpenMasterJournal(masterStore)
+	if err != nil {
+		return err
+	}
+	if promoted {
+		err = masterJournal.Reset()
+		if err != nil {
+			return err
+		}
+	}
+	err = masterJournal.Replay(&masterData)
+	if err != nil {
+		return err
+	}
+	masterDataMutex.Lock()
+	masterData.reindex()
+	resetChangedVersions(masterData.Version)
+	masterDataMutex.Unlock()
+	go compactJournalEvery(masterJournal, time.Duration(service.CompactEvery)*time.Second, &masterData)
+	masterHistory, err = OpenMasterDataHistory(service.HistoryDir, service.HistorySize)
+	if err != nil {
+		return err
+	}
+	err = masterHistory.Record(&masterData, commandType)
+	if err != nil {
+		return err
+	}
+	StartJobs()
+	atomic.StoreInt32(&activeMaster, 1)
+	currentMaster.Store("")
+	go SendLeases()
+	if strings.TrimSpace(service.Peer) != "" {
+		go WatchFence()
+	}
+	return nil
+}
+
+// refuseIfNotMaster turns away slaves registering to a standby, so that they
+// move on to the active master.
+func refuseIfNotMaster(conn *SlaveConn) bool {
+	if isMaster() {
+		return false
+	}
+	conn.Send("Not master.")
+	return true
+}
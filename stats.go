@@ -0,0 +1,270 @@
+// stats
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Every node counts the queries it executes. Slaves push the counts of the
+// last interval to the master in WS_STATS, where they are added up for the
+// whole cluster. Latencies are counted in fixed buckets, so that the counts of
+// different slaves can be added up and still give percentiles.
+
+var latencyBuckets = []float64{1, 2, 5, 10, 20, 50, 100, 200, 500, 1000, 2000, 5000, 10000}
+
+type QueryStats struct {
+	AppId    string
+	Query    string
+	Requests int64
+	Errors   int64
+	Rows     int64
+	// Latencies[i] counts the requests that took up to latencyBuckets[i]
+	// milliseconds, the last one those that took longer.
+	Latencies []int64
+}
+
+func NewQueryStats(appId string, query string) *QueryStats {
+	return &QueryStats{
+		AppId:     appId,
+		Query:     query,
+		Latencies: make([]int64, len(latencyBuckets)+1),
+	}
+}
+
+func (this *QueryStats) add(other *QueryStats) {
+	this.Requests += other.Requests
+	this.Errors += other.Errors
+	this.Rows += other.Rows
+	for i := range this.Latencies {
+		if i < len(other.Latencies) {
+			this.Latencies[i] += other.Latencies[i]
+		}
+	}
+}
+
+// Percentile returns the upper bound of the latency bucket, in milliseconds,
+// holding the p-th percentile request, -1 if above the largest bucket.
+func (this *QueryStats) Percentile(p float64) float64 {
+	var total int64
+	for _, v := range this.Latencies {
+		total += v
+	}
+	if total == 0 {
+		return 0
+	}
+	rank := int64(float64(total)*p/100 + 0.5)
+	if rank < 1 {
+		rank = 1
+	}
+	var count int64
+	for i, v := range this.Latencies {
+		count += v
+		if count >= rank {
+			if i < len(latencyBuckets) {
+				return latencyBuckets[i]
+			}
+			break
+		}
+	}
+	return -1
+}
+
+// StatsReport holds the stats of a node over an interval.
+type StatsReport struct {
+	NodeId string
+	Since  int64
+	Until  int64
+	Stats  map[string]*QueryStats
+}
+
+var statsMutex = &sync.Mutex{}
+var localStats = map[string]*QueryStats{}
+var localStatsSince = time.Now()
+
+func statsKey(appId string, query string) string {
+	return appId + "/" + query
+}
+
+// recordQuery counts one execution of a query on this node.
+func recordQuery(appId string, query string, elapsed time.Duration, result [][]interface{}, err error) {
+	statsMutex.Lock()
+	defer statsMutex.Unlock()
+	key := statsKey(appId, query)
+	stats, ok := localStats[key]
+	if !ok {
+		stats = NewQueryStats(appId, query)
+		localStats[key] = stats
+	}
+	stats.Requests++
+	if err != nil {
+		stats.Errors++
+	}
+	for _, v := range result {
+		value := reflect.ValueOf(v)
+		if value.Kind() == reflect.Slice {
+			stats.Rows += int64(value.Len())
+		}
+	}
+	ms := float64(elapsed) / float64(time.Millisecond)
+	bucket := sort.SearchFloat64s(latencyBuckets, ms)
+	stats.Latencies[bucket]++
+}
+
+// takeStats returns the stats recorded since the last call, and starts over.
+func takeStats() *StatsReport {
+	statsMutex.Lock()
+	defer statsMutex.Unlock()
+	report := &StatsReport{
+		NodeId: service.Id,
+		Since:  localStatsSince.Unix(),
+		Until:  time.Now().Unix(),
+		Stats:  localStats,
+	}
+	localStats = map[string]*QueryStats{}
+	localStatsSince = time.Now()
+	return report
+}
+
+// putBackStats adds back stats that could not be pushed to the master.
+func putBackStats(report *StatsReport) {
+	statsMutex.Lock()
+	defer statsMutex.Unlock()
+	for key, stats := range report.Stats {
+		if v, ok := localStats[key]; ok {
+			v.add(stats)
+		} else {
+			localStats[key] = stats
+		}
+	}
+	if report.Since < localStatsSince.Unix() {
+		localStatsSince = time.Unix(report.Since, 0)
+	}
+}
+
+var clusterStatsMutex = &sync.Mutex{}
+var clusterStats = map[string]*QueryStats{}
+var clusterStatsSince = time.Now()
+var statsReportedAt = map[string]int64{}
+
+// MergeStats adds the report of a node to the stats of the cluster.
+func MergeStats(report *StatsReport) {
+	clusterStatsMutex.Lock()
+	defer clusterStatsMutex.Unlock()
+	for key, stats := range report.Stats {
+		if v, ok := clusterStats[key]; ok {
+			v.add(stats)
+		} else {
+			merged := NewQueryStats(stats.AppId, stats.Query)
+			merged.add(stats)
+			clusterStats[key] = merged
+		}
+	}
+	statsReportedAt[report.NodeId] = report.Until
+}
+
+// PushStats sends the stats of this node to the master every interval, or
+// merges them directly on the master.
+func PushStats(interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	for range time.Tick(interval) {
+		report := takeStats()
+		if len(report.Stats) == 0 {
+			continue
+		}
+		if isMaster() {
+			MergeStats(report)
+			continue
+		}
+		conn := slaveConn
+		if conn == nil || getCurrentMaster() == "" {
+			putBackStats(report)
+			continue
+		}
+		err := sendStats(conn, report)
+		if err != nil {
+			log.Println("Failed to push stats:", err)
+			putBackStats(report)
+		}
+	}
+}
+
+func sendStats(conn *websocket.Conn, report *StatsReport) error {
+	reportBytes, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	return writeToMaster(conn, &Command{
+		Type: "WS_STATS",
+		Data: string(reportBytes),
+	})
+}
+
+// ListStats renders the stats of the cluster, for one app if appId is set,
+// with a total line per app.
+func ListStats(appId string) string {
+	clusterStatsMutex.Lock()
+	byApp := map[string][]*QueryStats{}
+	for _, stats := range clusterStats {
+		if appId != "" && stats.AppId != appId {
+			continue
+		}
+		copied := NewQueryStats(stats.AppId, stats.Query)
+		copied.add(stats)
+		byApp[stats.AppId] = append(byApp[stats.AppId], copied)
+	}
+	nodes := len(statsReportedAt)
+	since := clusterStatsSince
+	clusterStatsMutex.Unlock()
+
+	index := getMasterIndex()
+	appIds := []string{}
+	for id := range byApp {
+		appIds = append(appIds, id)
+	}
+	sort.Strings(appIds)
+
+	var buffer bytes.Buffer
+	w := tabwriter.NewWriter(&buffer, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "APP\tQUERY\tREQUESTS\tERRORS\tROWS\tP50 MS\tP95 MS\tP99 MS")
+	line := func(appName string, stats *QueryStats) {
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\n", appName, stats.Query, stats.Requests, stats.Errors, stats.Rows,
+			formatLatency(stats.Percentile(50)), formatLatency(stats.Percentile(95)), formatLatency(stats.Percentile(99)))
+	}
+	for _, id := range appIds {
+		appName := id
+		if app, err := index.App(id); err == nil {
+			appName = app.Name
+		}
+		queries := byApp[id]
+		sort.Slice(queries, func(i, j int) bool { return queries[i].Query < queries[j].Query })
+		total := NewQueryStats(id, "*")
+		for _, stats := range queries {
+			line(appName, stats)
+			total.add(stats)
+		}
+		line(appName, total)
+	}
+	w.Flush()
+	buffer.WriteString(fmt.Sprintln("Since", since.Format(time.RFC3339)+",", nodes, "nodes reporting."))
+	return buffer.String()
+}
+
+func formatLatency(ms float64) string {
+	if ms < 0 {
+		return fmt.Sprint(">", latencyBuckets[len(latencyBuckets)-1])
+	}
+	return strings.TrimSuffix(fmt.Sprint("<=", ms), ".0")
+}
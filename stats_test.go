@@ -0,0 +1,50 @@
+// stats_test
+package main
+
+import (
+	"testing"
+)
+
+func TestPercentile(t *testing.T) {
+	tests := []struct {
+		name      string
+		latencies map[int]int64
+		p         float64
+		want      float64
+	}{
+		{"no requests", map[int]int64{}, 50, 0},
+		{"single bucket", map[int]int64{3: 10}, 99, 10},
+		{"median", map[int]int64{0: 50, 5: 50}, 50, 1},
+		{"just above median", map[int]int64{0: 49, 5: 51}, 50, 50},
+		{"p95", map[int]int64{0: 90, 4: 5, 8: 5}, 95, 20},
+		{"p99", map[int]int64{0: 90, 4: 5, 8: 5}, 99, 500},
+		{"lowest percentile", map[int]int64{2: 1, 6: 1}, 0, 5},
+		{"above the largest bucket", map[int]int64{0: 1, len(latencyBuckets): 9}, 50, -1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			stats := NewQueryStats("app1", "q1")
+			for i, v := range test.latencies {
+				stats.Latencies[i] = v
+			}
+			if got := stats.Percentile(test.p); got != test.want {
+				t.Errorf("Percentile(%v) = %v, want %v", test.p, got, test.want)
+			}
+		})
+	}
+}
+
+func TestQueryStatsAdd(t *testing.T) {
+	stats := NewQueryStats("app1", "q1")
+	stats.Latencies[0] = 2
+	other := NewQueryStats("app1", "q1")
+	other.Requests, other.Errors, other.Rows = 3, 1, 7
+	other.Latencies[0], other.Latencies[5] = 1, 2
+	stats.add(other)
+	if stats.Requests != 3 || stats.Errors != 1 || stats.Rows != 7 {
+		t.Errorf("counts = %v, %v, %v, want 3, 1, 7", stats.Requests, stats.Errors, stats.Rows)
+	}
+	if stats.Latencies[0] != 3 || stats.Latencies[5] != 2 {
+		t.Errorf("latencies = %v", stats.Latencies)
+	}
+}
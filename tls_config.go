@@ -0,0 +1,95 @@
+// tls_config
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// clientTLSConfig is used by the cli, slaves and remote interceptors to
+// connect over https. The server is verified against the cluster CA if set,
+// the system roots otherwise, unless insecure mode has been opted in to. The
+// client certificate is only presented to cluster nodes.
+func clientTLSConfig(clusterNode bool) (*tls.Config, error) {
+	if service.Insecure {
+		return &tls.Config{InsecureSkipVerify: true}, nil
+	}
+	config := &tls.Config{}
+	if strings.TrimSpace(service.CaFile) != "" {
+		pool, err := loadCertPool(service.CaFile)
+		if err != nil {
+			return nil, err
+		}
+		if clusterNode {
+			config.RootCAs = pool
+		} else {
+			// Remote interceptors may call services outside of the cluster.
+			systemPool, err := x509.SystemCertPool()
+			if err != nil {
+				systemPool = x509.NewCertPool()
+			}
+			caBytes, err := ioutil.ReadFile(service.CaFile)
+			if err != nil {
+				return nil, err
+			}
+			systemPool.AppendCertsFromPEM(caBytes)
+			config.RootCAs = systemPool
+		}
+	}
+	if clusterNode && strings.TrimSpace(service.ClientCertFile) != "" {
+		cert, err := tls.LoadX509KeyPair(service.ClientCertFile, service.ClientKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// serverTLSConfig asks clients for a certificate signed by the cluster CA.
+// It is optional for /api clients, and enforced by requireClientCert on the
+// /sys endpoints.
+func serverTLSConfig() (*tls.Config, error) {
+	config := &tls.Config{}
+	if strings.TrimSpace(service.CaFile) != "" {
+		pool, err := loadCertPool(service.CaFile)
+		if err != nil {
+			return nil, err
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+	} else if !service.Insecure {
+		log.Println("No ca_file configured, cluster nodes and cli are not authenticated by certificate.")
+	}
+	return config, nil
+}
+
+func loadCertPool(caFile string) (*x509.CertPool, error) {
+	caBytes, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caBytes) {
+		return nil, errors.New("No certificate found in ca file: " + caFile)
+	}
+	return pool, nil
+}
+
+// requireClientCert rejects requests to the /sys endpoints without a client
+// certificate verified against the cluster CA, when one is configured.
+func requireClientCert(w http.ResponseWriter, r *http.Request) bool {
+	if service.Insecure || strings.TrimSpace(service.CaFile) == "" {
+		return true
+	}
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		http.Error(w, "Client certificate required.", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
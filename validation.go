@@ -0,0 +1,194 @@
+// validation
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/elgs/cron"
+)
+
+// ValidationErrors collects the field errors of an entity, so that they can
+// all be reported at once.
+type ValidationErrors struct {
+	Entity string
+	Name   string
+	Errors []string
+}
+
+func (this *ValidationErrors) Error() string {
+	if this.Name == "" {
+		return fmt.Sprint("Invalid ", this.Entity, ": ", strings.Join(this.Errors, "; "))
+	}
+	return fmt.Sprint("Invalid ", this.Entity, " ", this.Name, ": ", strings.Join(this.Errors, "; "))
+}
+
+func (this *ValidationErrors) add(field string, message string) {
+	this.Errors = append(this.Errors, field+" "+message)
+}
+
+func (this *ValidationErrors) required(field string, value string) {
+	if strings.TrimSpace(value) == "" {
+		this.add(field, "is required")
+	}
+}
+
+func (this *ValidationErrors) oneOf(field string, value string, allowed ...string) {
+	for _, v := range allowed {
+		if v == value {
+			return
+		}
+	}
+	this.add(field, fmt.Sprintf("must be one of %s, got: %q", strings.Join(allowed, ", "), value))
+}
+
+// errorOrNil returns nil when there is nothing to report, so that callers do
+// not get a non-nil error interface holding an empty ValidationErrors.
+func (this *ValidationErrors) errorOrNil() error {
+	if len(this.Errors) == 0 {
+		return nil
+	}
+	return this
+}
+
+var tokenModes = []string{"create", "load", "update", "duplicate", "delete", "list", "exec"}
+var interceptorTypes = []string{"before", "after"}
+var remoteInterceptorMethods = []string{"GET", "POST", "PUT", "PATCH", "DELETE"}
+
+func (this *DataNode) Validate() error {
+	errs := &ValidationErrors{Entity: "data node", Name: this.Name}
+	errs.required("Name", this.Name)
+	errs.required("Host", this.Host)
+	if this.Port < 1 || this.Port > 65535 {
+		errs.add("Port", fmt.Sprint("must be between 1 and 65535, got: ", this.Port))
+	}
+	return errs.errorOrNil()
+}
+
+func (this *App) Validate() error {
+	errs := &ValidationErrors{Entity: "app", Name: this.Name}
+	errs.required("Name", this.Name)
+	errs.required("DataNodeId", this.DataNodeId)
+	return errs.errorOrNil()
+}
+
+func (this *Query) Validate() error {
+	errs := &ValidationErrors{Entity: "query", Name: this.Name}
+	errs.required("Name", this.Name)
+	errs.required("AppId", this.AppId)
+	errs.oneOf("Mode", this.Mode, "", "public", "private")
+	errs.oneOf("ScriptSource", this.ScriptSource, "", ScriptSourcePath, ScriptSourceText)
+	if this.ScriptSource == ScriptSourceText {
+		errs.required("ScriptText", this.ScriptText)
+	}
+	return errs.errorOrNil()
+}
+
+func (this *Job) Validate() error {
+	errs := &ValidationErrors{Entity: "job", Name: this.Name}
+	errs.required("Name", this.Name)
+	errs.required("AppId", this.AppId)
+	if strings.TrimSpace(this.Cron) == "" {
+		errs.add("Cron", "is required")
+	} else if _, err := cron.Parse(this.Cron); err != nil {
+		errs.add("Cron", "is invalid: "+err.Error())
+	}
+	if this.AutoStart != 0 && this.AutoStart != 1 {
+		errs.add("AutoStart", fmt.Sprint("must be 0 or 1, got: ", this.AutoStart))
+	}
+	errs.oneOf("ScriptSource", this.ScriptSource, "", ScriptSourcePath, ScriptSourceText)
+	if this.ScriptSource == ScriptSourceText {
+		errs.required("ScriptText", this.ScriptText)
+	}
+	errs.oneOf("LoopScriptSource", this.LoopScriptSource, "", ScriptSourcePath, ScriptSourceText)
+	if this.LoopScriptSource == ScriptSourceText {
+		errs.required("LoopScriptText", this.LoopScriptText)
+	}
+	return errs.errorOrNil()
+}
+
+func (this *Token) Validate() error {
+	errs := &ValidationErrors{Entity: "token", Name: this.Name}
+	errs.required("Name", this.Name)
+	errs.required("AppId", this.AppId)
+	errs.required("Target", this.Target)
+	if strings.TrimSpace(this.Mode) == "" {
+		errs.add("Mode", "is required")
+	} else if this.Mode != "*" {
+		for _, mode := range strings.Split(this.Mode, ",") {
+			found := false
+			for _, v := range tokenModes {
+				found = found || v == strings.TrimSpace(mode)
+			}
+			if !found {
+				errs.add("Mode", fmt.Sprintf("must be * or a comma separated list of %s, got: %q", strings.Join(tokenModes, ", "), mode))
+			}
+		}
+	}
+	return errs.errorOrNil()
+}
+
+func (this *LocalInterceptor) Validate() error {
+	errs := &ValidationErrors{Entity: "local interceptor", Name: this.Name}
+	errs.required("Name", this.Name)
+	errs.required("AppId", this.AppId)
+	errs.required("Target", this.Target)
+	errs.required("Callback", this.Callback)
+	errs.oneOf("Type", this.Type, interceptorTypes...)
+	return errs.errorOrNil()
+}
+
+func (this *RemoteInterceptor) Validate() error {
+	errs := &ValidationErrors{Entity: "remote interceptor", Name: this.Name}
+	errs.required("Name", this.Name)
+	errs.required("AppId", this.AppId)
+	errs.required("Target", this.Target)
+	if strings.TrimSpace(this.Url) == "" {
+		errs.add("Url", "is required")
+	} else if u, err := url.Parse(this.Url); err != nil {
+		errs.add("Url", "is invalid: "+err.Error())
+	} else if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		errs.add("Url", "must be an absolute http or https url, got: "+this.Url)
+	}
+	errs.oneOf("Method", this.Method, remoteInterceptorMethods...)
+	errs.oneOf("Type", this.Type, interceptorTypes...)
+	errs.oneOf("ActionType", this.ActionType, tokenModes...)
+	return errs.errorOrNil()
+}
+
+// Validate validates every entity of the master data, as a whole set of
+// master data is applied by import.
+func (this *MasterData) Validate() error {
+	messages := []string{}
+	check := func(err error) {
+		if err != nil {
+			messages = append(messages, err.Error())
+		}
+	}
+	for _, dataNode := range this.DataNodes {
+		check(dataNode.Validate())
+	}
+	for _, app := range this.Apps {
+		check(app.Validate())
+		for _, query := range app.Queries {
+			check(query.Validate())
+		}
+		for _, job := range app.Jobs {
+			check(job.Validate())
+		}
+		for _, token := range app.Tokens {
+			check(token.Validate())
+		}
+		for _, li := range app.LocalInterceptors {
+			check(li.Validate())
+		}
+		for _, ri := range app.RemoteInterceptors {
+			check(ri.Validate())
+		}
+	}
+	if len(messages) > 0 {
+		return &ValidationErrors{Entity: "master data", Errors: messages}
+	}
+	return nil
+}
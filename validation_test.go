@@ -0,0 +1,86 @@
+// validation_test
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+type validator interface {
+	Validate() error
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name       string
+		entity     validator
+		wantFields []string
+	}{
+		{"valid data node", &DataNode{Name: "dn1", Host: "localhost", Port: 3306}, nil},
+		{"data node without name and host", &DataNode{Port: 3306}, []string{"Name", "Host"}},
+		{"data node port out of range", &DataNode{Name: "dn1", Host: "localhost", Port: 70000}, []string{"Port"}},
+		{"valid app", &App{Name: "app1", DataNodeId: "dn1"}, nil},
+		{"app without data node", &App{Name: "app1"}, []string{"DataNodeId"}},
+		{"valid query", &Query{Name: "q1", AppId: "app1", Mode: "public"}, nil},
+		{"query with unknown mode", &Query{Name: "q1", AppId: "app1", Mode: "open"}, []string{"Mode"}},
+		{"query text source without text", &Query{Name: "q1", AppId: "app1", ScriptSource: ScriptSourceText}, []string{"ScriptText"}},
+		{"query with unknown script source", &Query{Name: "q1", AppId: "app1", ScriptSource: "url"}, []string{"ScriptSource"}},
+		{"valid job", &Job{Name: "j1", AppId: "app1", Cron: "0 * * * * *"}, nil},
+		{"job without cron", &Job{Name: "j1", AppId: "app1"}, []string{"Cron"}},
+		{"job with bad auto start", &Job{Name: "j1", AppId: "app1", Cron: "0 * * * * *", AutoStart: 2}, []string{"AutoStart"}},
+		{"job text source without text", &Job{Name: "j1", AppId: "app1", Cron: "0 * * * * *", ScriptSource: ScriptSourceText}, []string{"ScriptText"}},
+		{"job loop text source without text", &Job{Name: "j1", AppId: "app1", Cron: "0 * * * * *", LoopScriptSource: ScriptSourceText}, []string{"LoopScriptText"}},
+		{"job with unknown loop source", &Job{Name: "j1", AppId: "app1", Cron: "0 * * * * *", LoopScriptSource: "url"}, []string{"LoopScriptSource"}},
+		{"valid token", &Token{Name: "t1", AppId: "app1", Target: "*", Mode: "load, list"}, nil},
+		{"token with any mode", &Token{Name: "t1", AppId: "app1", Target: "*", Mode: "*"}, nil},
+		{"token with unknown mode", &Token{Name: "t1", AppId: "app1", Target: "*", Mode: "load,drop"}, []string{"Mode"}},
+		{"valid local interceptor", &LocalInterceptor{Name: "li1", AppId: "app1", Target: "*", Callback: "cb", Type: "before"}, nil},
+		{"local interceptor with unknown type", &LocalInterceptor{Name: "li1", AppId: "app1", Target: "*", Callback: "cb", Type: "around"}, []string{"Type"}},
+		{"valid remote interceptor", &RemoteInterceptor{Name: "ri1", AppId: "app1", Target: "*", Url: "https://example.com/hook", Method: "POST", Type: "after", ActionType: "create"}, nil},
+		{"remote interceptor with relative url", &RemoteInterceptor{Name: "ri1", AppId: "app1", Target: "*", Url: "/hook", Method: "POST", Type: "after", ActionType: "create"}, []string{"Url"}},
+		{"remote interceptor with bad method and action", &RemoteInterceptor{Name: "ri1", AppId: "app1", Target: "*", Url: "http://example.com", Method: "HEAD", Type: "after", ActionType: "drop"}, []string{"Method", "ActionType"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.entity.Validate()
+			if len(test.wantFields) == 0 {
+				if err != nil {
+					t.Errorf("err = %v, want nil", err)
+				}
+				return
+			}
+			errs, ok := err.(*ValidationErrors)
+			if !ok {
+				t.Fatalf("err = %v, want *ValidationErrors", err)
+			}
+			if len(errs.Errors) != len(test.wantFields) {
+				t.Fatalf("errors = %q, want one for each of %v", errs.Errors, test.wantFields)
+			}
+			for i, field := range test.wantFields {
+				if !strings.HasPrefix(errs.Errors[i], field+" ") {
+					t.Errorf("error %v = %q, want one about %v", i, errs.Errors[i], field)
+				}
+			}
+		})
+	}
+}
+
+func TestMasterDataValidate(t *testing.T) {
+	masterData := &MasterData{
+		DataNodes: []*DataNode{{Name: "dn1", Port: 3306}},
+		Apps: []*App{{
+			Name:       "app1",
+			DataNodeId: "dn1",
+			Queries:    []*Query{{Name: "q1", Mode: "open"}},
+		}},
+	}
+	err := masterData.Validate()
+	if err == nil {
+		t.Fatal("err = nil, want the errors of the data node and the query")
+	}
+	for _, want := range []string{"data node dn1: Host is required", "query q1: AppId is required; Mode must be one of"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("err = %v, want it to contain %q", err, want)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+// ws_auth
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+)
+
+// Slaves register with a challenge response handshake: the master sends a
+// random nonce in WS_CHALLENGE, and the slave answers with WS_REGISTER, which
+// carries a SlaveRegistration signed with the shared secret instead of the
+// secret itself.
+
+// SlaveRegistration holds what a slave tells the master about itself.
+type SlaveRegistration struct {
+	Id           string
+	Standby      bool
+	HttpPort     int
+	HttpsPort    int
+	EnableHttp   bool
+	EnableHttps  bool
+	Capabilities []string
+	// Public endpoint and region labels, for clients to discover api nodes.
+	ServerName  string
+	ServerIP4   string
+	ServerIP6   string
+	ServerPort  int
+	CountryCode string
+	Region      string
+	SuperRegion string
+	Proof       string
+}
+
+// slaveCapabilities lists the parts of the master slave protocol this node
+// understands.
+var slaveCapabilities = []string{"patch", "ack", "resync", "lease", "heartbeat"}
+
+func NewSlaveRegistration(nonce string) *SlaveRegistration {
+	return &SlaveRegistration{
+		Id:           service.Id,
+		Standby:      service.Standby,
+		HttpPort:     service.HttpPort,
+		HttpsPort:    service.HttpsPort,
+		EnableHttp:   service.EnableHttp,
+		EnableHttps:  service.EnableHttps,
+		Capabilities: slaveCapabilities,
+		ServerName:   service.ServerName,
+		ServerIP4:    service.ServerIP4,
+		ServerIP6:    service.ServerIP6,
+		ServerPort:   serverPort(),
+		CountryCode:  service.CountryCode,
+		Region:       service.Region,
+		SuperRegion:  service.SuperRegion,
+		Proof:        registrationProof(service.Secret, nonce, service.Id),
+	}
+}
+
+func serverPort() int {
+	if service.ServerPort > 0 {
+		return service.ServerPort
+	}
+	if service.EnableHttps {
+		return service.HttpsPort
+	}
+	return service.HttpPort
+}
+
+func registrationProof(secret string, nonce string, id string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(nonce + ":" + id))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (this *SlaveRegistration) Verify(nonce string) bool {
+	if nonce == "" {
+		return false
+	}
+	expected := registrationProof(service.Secret, nonce, this.Id)
+	return hmac.Equal([]byte(expected), []byte(this.Proof))
+}
+
+var challengesMutex = &sync.Mutex{}
+var challenges = map[*SlaveConn]string{}
+
+// issueChallenge sends a new nonce to a connecting slave.
+func issueChallenge(conn *SlaveConn) error {
+	nonceBytes := make([]byte, 32)
+	_, err := rand.Read(nonceBytes)
+	if err != nil {
+		return err
+	}
+	nonce := hex.EncodeToString(nonceBytes)
+	challengesMutex.Lock()
+	challenges[conn] = nonce
+	challengesMutex.Unlock()
+	return conn.Send(&Command{
+		Type: "WS_CHALLENGE",
+		Data: nonce,
+	})
+}
+
+// takeChallenge returns the nonce issued to conn, which can only be used once.
+func takeChallenge(conn *SlaveConn) string {
+	challengesMutex.Lock()
+	defer challengesMutex.Unlock()
+	nonce := challenges[conn]
+	delete(challenges, conn)
+	return nonce
+}
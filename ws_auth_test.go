@@ -0,0 +1,51 @@
+// ws_auth_test
+package main
+
+import (
+	"testing"
+)
+
+func TestSlaveRegistrationVerify(t *testing.T) {
+	id, secret := service.Id, service.Secret
+	defer func() {
+		service.Id, service.Secret = id, secret
+	}()
+	service.Id, service.Secret = "slave1", "s3cret"
+
+	registration := NewSlaveRegistration("nonce1")
+	if !registration.Verify("nonce1") {
+		t.Fatal("valid proof rejected")
+	}
+	if registration.Verify("nonce2") {
+		t.Error("proof accepted for another nonce")
+	}
+	if registration.Verify("") {
+		t.Error("proof accepted without a nonce")
+	}
+
+	// The proof binds the id the slave registers with.
+	registration.Id = "slave2"
+	if registration.Verify("nonce1") {
+		t.Error("proof accepted for another id")
+	}
+
+	service.Secret = "guess"
+	guessed := NewSlaveRegistration("nonce1")
+	service.Secret = "s3cret"
+	if guessed.Verify("nonce1") {
+		t.Error("proof made with a wrong secret accepted")
+	}
+}
+
+func TestTakeChallenge(t *testing.T) {
+	conn := &SlaveConn{}
+	challengesMutex.Lock()
+	challenges[conn] = "nonce1"
+	challengesMutex.Unlock()
+	if nonce := takeChallenge(conn); nonce != "nonce1" {
+		t.Errorf("nonce = %q, want nonce1", nonce)
+	}
+	if nonce := takeChallenge(conn); nonce != "" {
+		t.Errorf("nonce = %q on reuse, want none", nonce)
+	}
+}